@@ -8,12 +8,32 @@ import (
 )
 
 func main() {
-	var addr string
+	var addr, configPath, profile, tlsCert, tlsKey, clientCA string
 	flag.StringVar(&addr, "addr", ":8080", "HTTP server address")
+	flag.StringVar(&tlsCert, "tls-cert", "", "Certificate file to serve HTTPS (default: http.tls_cert)")
+	flag.StringVar(&tlsKey, "tls-key", "", "Private key file of -tls-cert (default: http.tls_key)")
+	flag.StringVar(&clientCA, "client-ca", "", "CA file to verify client certificates against (default: http.client_ca)")
+	flag.StringVar(&configPath, "config", "", "Path to a YAML or TOML config file (default: $MYSQL_MCP_CONFIG)")
+	flag.StringVar(&profile, "profile", "", "Connection profile to use (default: $MYSQL_PROFILE)")
 	flag.Parse()
 
+	// Load configuration
+	cfg, err := internal.LoadConfig(configPath, profile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if tlsCert != "" {
+		cfg.HTTP.TLSCert = tlsCert
+	}
+	if tlsKey != "" {
+		cfg.HTTP.TLSKey = tlsKey
+	}
+	if clientCA != "" {
+		cfg.HTTP.ClientCA = clientCA
+	}
+
 	// Create MySQL server
-	ms, err := internal.NewMySQLServer()
+	ms, err := internal.NewMySQLServer(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create MySQL server: %v", err)
 	}
@@ -23,4 +43,4 @@ func main() {
 	if err := internal.StartHTTPServer(ms, addr); err != nil {
 		log.Fatalf("HTTP server error: %v", err)
 	}
-}
\ No newline at end of file
+}
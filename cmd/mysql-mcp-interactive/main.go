@@ -1,14 +1,26 @@
 package main
 
 import (
+	"flag"
 	"log"
 
 	"go_mysql_mcp/internal"
 )
 
 func main() {
+	var configPath, profile string
+	flag.StringVar(&configPath, "config", "", "Path to a YAML or TOML config file (default: $MYSQL_MCP_CONFIG)")
+	flag.StringVar(&profile, "profile", "", "Connection profile to use (default: $MYSQL_PROFILE)")
+	flag.Parse()
+
+	// Load configuration
+	cfg, err := internal.LoadConfig(configPath, profile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
 	// Create MySQL server
-	ms, err := internal.NewMySQLServer()
+	ms, err := internal.NewMySQLServer(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create MySQL server: %v", err)
 	}
@@ -16,4 +28,4 @@ func main() {
 
 	// Run interactive mode
 	internal.RunInteractiveMode(ms)
-}
\ No newline at end of file
+}
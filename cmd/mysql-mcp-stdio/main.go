@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"log"
 
 	"github.com/mark3labs/mcp-go/server"
@@ -8,8 +9,19 @@ import (
 )
 
 func main() {
+	var configPath, profile string
+	flag.StringVar(&configPath, "config", "", "Path to a YAML or TOML config file (default: $MYSQL_MCP_CONFIG)")
+	flag.StringVar(&profile, "profile", "", "Connection profile to use (default: $MYSQL_PROFILE)")
+	flag.Parse()
+
+	// Load configuration
+	cfg, err := internal.LoadConfig(configPath, profile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
 	// Create MySQL server
-	ms, err := internal.NewMySQLServer()
+	ms, err := internal.NewMySQLServer(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create MySQL server: %v", err)
 	}
@@ -21,4 +33,4 @@ func main() {
 	if err := server.ServeStdio(s); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
-}
\ No newline at end of file
+}
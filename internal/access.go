@@ -0,0 +1,542 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/tidb/pkg/parser/ast"
+)
+
+// namePattern is a glob pattern for a schema, table or column name. Each
+// part matches case-insensitively, with * for any run of characters and ?
+// for a single character; an omitted part matches anything.
+type namePattern struct {
+	schema string
+	table  string
+	column string
+}
+
+// accessPolicy hides schemas, tables and columns from every tool. A name is
+// visible when it matches an allow pattern (or there are none) and no deny
+// pattern; tables of hidden schemas and columns of hidden tables are hidden
+// as well.
+type accessPolicy struct {
+	allowSchemas []namePattern
+	denySchemas  []namePattern
+	allowTables  []namePattern
+	denyTables   []namePattern
+	allowColumns []namePattern
+	denyColumns  []namePattern
+
+	// scopeSchemas further restricts schemas to those granted to an HTTP
+	// caller
+	scopeSchemas []namePattern
+}
+
+// newAccessPolicy parses the patterns of cfg. Table patterns have the form
+// [schema.]table and column patterns [[schema.]table.]column.
+func newAccessPolicy(cfg AccessConfig) (*accessPolicy, error) {
+	p := &accessPolicy{}
+	for _, rule := range []struct {
+		key      string
+		items    []string
+		parts    int
+		patterns *[]namePattern
+	}{
+		{"access.allow_schemas", cfg.AllowSchemas, 1, &p.allowSchemas},
+		{"access.deny_schemas", cfg.DenySchemas, 1, &p.denySchemas},
+		{"access.allow_tables", cfg.AllowTables, 2, &p.allowTables},
+		{"access.deny_tables", cfg.DenyTables, 2, &p.denyTables},
+		{"access.allow_columns", cfg.AllowColumns, 3, &p.allowColumns},
+		{"access.deny_columns", cfg.DenyColumns, 3, &p.denyColumns},
+	} {
+		patterns, err := parseNamePatterns(rule.items, rule.parts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", configKey(rule.key), err)
+		}
+		*rule.patterns = patterns
+	}
+	return p, nil
+}
+
+// parseNamePatterns parses patterns with up to maxParts dot separated parts,
+// the last part being the most specific.
+func parseNamePatterns(items []string, maxParts int) ([]namePattern, error) {
+	var patterns []namePattern
+	for _, item := range items {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ".")
+		if len(parts) > maxParts {
+			return nil, fmt.Errorf("pattern %q has too many parts", item)
+		}
+		for _, part := range parts {
+			if part == "" {
+				return nil, fmt.Errorf("pattern %q has an empty part", item)
+			}
+		}
+
+		// Fill in from the most specific part, missing parts match anything
+		fields := []string{"*", "*", "*"}
+		copy(fields[maxParts-len(parts):], parts)
+		switch maxParts {
+		case 1:
+			patterns = append(patterns, namePattern{schema: fields[0]})
+		case 2:
+			patterns = append(patterns, namePattern{schema: fields[0], table: fields[1]})
+		default:
+			patterns = append(patterns, namePattern{schema: fields[0], table: fields[1], column: fields[2]})
+		}
+	}
+	return patterns, nil
+}
+
+func (p namePattern) matches(schema, table, column string) bool {
+	return globMatch(p.schema, schema) &&
+		(p.table == "" || globMatch(p.table, table)) &&
+		(p.column == "" || globMatch(p.column, column))
+}
+
+// globMatch matches name against a lower case pattern with * and ?.
+func globMatch(pattern, name string) bool {
+	name = strings.ToLower(name)
+	p, n := []rune(pattern), []rune(name)
+	// Position to resume from after the last *
+	star, resume := -1, 0
+	i, j := 0, 0
+	for j < len(n) {
+		switch {
+		case i < len(p) && (p[i] == '?' || p[i] == n[j]):
+			i++
+			j++
+		case i < len(p) && p[i] == '*':
+			star, resume = i, j
+			i++
+		case star >= 0:
+			resume++
+			i, j = star+1, resume
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
+
+func visible(allow, deny []namePattern, schema, table, column string) bool {
+	if len(allow) > 0 {
+		allowed := false
+		for _, p := range allow {
+			if p.matches(schema, table, column) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	for _, p := range deny {
+		if p.matches(schema, table, column) {
+			return false
+		}
+	}
+	return true
+}
+
+// restrict returns the policy limited to the schemas matching scope, or p
+// when scope is empty.
+func (p *accessPolicy) restrict(scope []namePattern) *accessPolicy {
+	if len(scope) == 0 {
+		return p
+	}
+	restricted := *p
+	restricted.scopeSchemas = scope
+	return &restricted
+}
+
+// accessFor returns the access policy of a tool call, restricted to the
+// schemas of its HTTP caller.
+func (ms *MySQLServer) accessFor(ctx context.Context) *accessPolicy {
+	if id := identityFromContext(ctx); id != nil {
+		return ms.access.restrict(id.schemas)
+	}
+	return ms.access
+}
+
+func (p *accessPolicy) schemaAllowed(schema string) bool {
+	return visible(p.allowSchemas, p.denySchemas, schema, "", "") && visible(p.scopeSchemas, nil, schema, "", "")
+}
+
+func (p *accessPolicy) tableAllowed(schema, table string) bool {
+	return p.schemaAllowed(schema) && visible(p.allowTables, p.denyTables, schema, table, "")
+}
+
+func (p *accessPolicy) columnAllowed(schema, table, column string) bool {
+	return p.tableAllowed(schema, table) && visible(p.allowColumns, p.denyColumns, schema, table, column)
+}
+
+func (p *accessPolicy) hasSchemaRules() bool {
+	return len(p.allowSchemas) > 0 || len(p.denySchemas) > 0 || len(p.scopeSchemas) > 0
+}
+
+func (p *accessPolicy) hasColumnRules() bool {
+	return len(p.allowColumns) > 0 || len(p.denyColumns) > 0
+}
+
+func (p *accessPolicy) hasRules() bool {
+	return p.hasSchemaRules() || len(p.allowTables) > 0 || len(p.denyTables) > 0 || p.hasColumnRules()
+}
+
+// systemSchemas describe every schema, table and column of the server and
+// cannot be filtered by the access rules.
+var systemSchemas = map[string]bool{
+	"information_schema": true,
+	"performance_schema": true,
+	"mysql":              true,
+	"sys":                true,
+}
+
+// schemaFilter returns SQL conditions restricting schemaCol to visible
+// schemas, for listings read from information_schema.
+func (p *accessPolicy) schemaFilter(schemaCol string) ([]string, []any) {
+	conds, args := sqlPatternFilter(p.allowSchemas, p.denySchemas, schemaCol, "")
+	scopeConds, scopeArgs := sqlPatternFilter(p.scopeSchemas, nil, schemaCol, "")
+	return append(conds, scopeConds...), append(args, scopeArgs...)
+}
+
+// tableFilter returns SQL conditions restricting schemaCol and tableCol to
+// visible tables.
+func (p *accessPolicy) tableFilter(schemaCol, tableCol string) ([]string, []any) {
+	conds, args := p.schemaFilter(schemaCol)
+	tableConds, tableArgs := sqlPatternFilter(p.allowTables, p.denyTables, schemaCol, tableCol)
+	return append(conds, tableConds...), append(args, tableArgs...)
+}
+
+func sqlPatternFilter(allow, deny []namePattern, schemaCol, tableCol string) ([]string, []any) {
+	var conds []string
+	var args []any
+
+	match := func(patterns []namePattern) string {
+		var terms []string
+		for _, p := range patterns {
+			term := "LOWER(" + schemaCol + ") LIKE ?"
+			args = append(args, likePattern(p.schema))
+			if tableCol != "" {
+				term += " AND LOWER(" + tableCol + ") LIKE ?"
+				args = append(args, likePattern(p.table))
+			}
+			terms = append(terms, "("+term+")")
+		}
+		return "(" + strings.Join(terms, " OR ") + ")"
+	}
+
+	if len(allow) > 0 {
+		conds = append(conds, match(allow))
+	}
+	if len(deny) > 0 {
+		conds = append(conds, "NOT "+match(deny))
+	}
+	return conds, args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`, "*", "%", "?", "_")
+
+// likePattern converts a glob pattern to a LIKE pattern.
+func likePattern(glob string) string {
+	return likeEscaper.Replace(glob)
+}
+
+// notAccessibleError is returned for hidden objects so that their
+// existence is not revealed.
+func notAccessibleError(schema, table string) error {
+	if table == "" {
+		return fmt.Errorf("schema %s does not exist or is not accessible", schema)
+	}
+	return fmt.Errorf("table %s.%s does not exist or is not accessible", schema, table)
+}
+
+// tableColumnNames returns the column names of a table.
+func tableColumnNames(ctx context.Context, q queryer, schema, table string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT COLUMN_NAME
+		FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?
+		ORDER BY ORDINAL_POSITION`, schema, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan column: %w", err)
+		}
+		columns = append(columns, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	return columns, nil
+}
+
+// checkStatementAccess rejects a statement that reads a hidden schema, table
+// or column. Tables are resolved from the parse tree, unqualified names
+// against the session's default schema (or the FROM schema of a SHOW
+// statement). Column references that cannot be tied to a single table are
+// checked against every table of the statement, and * is rejected over
+// tables with hidden columns. With access rules configured, the system
+// schemas are rejected, since they would list hidden names.
+func (ms *MySQLServer) checkStatementAccess(ctx context.Context, sess *dbSession, stmt ast.StmtNode) error {
+	access := ms.accessFor(ctx)
+	refs := newTableRefCollector()
+	stmt.Accept(refs)
+
+	defaultSchema := sess.database
+	show, ok := stmt.(*ast.ShowStmt)
+	if explain, isExplain := stmt.(*ast.ExplainStmt); isExplain {
+		show, ok = explain.Stmt.(*ast.ShowStmt)
+	}
+	if ok {
+		if show.DBName != "" {
+			defaultSchema = show.DBName
+		}
+		if err := checkShowAccess(access, show, defaultSchema); err != nil {
+			return err
+		}
+	}
+
+	var tables []tableRef
+	for _, t := range refs.tables {
+		if t.schema == "" {
+			t.schema = defaultSchema
+		}
+		if access.hasRules() && systemSchemas[strings.ToLower(t.schema)] {
+			return fmt.Errorf("query rejected: %s is not accessible while schemas, tables or columns are restricted, use list_schemas, list_tables and get_table_structure instead", strings.ToLower(t.schema))
+		}
+		if !access.tableAllowed(t.schema, t.name) {
+			return notAccessibleError(t.schema, t.name)
+		}
+		tables = append(tables, t)
+	}
+
+	if !access.hasColumnRules() || len(tables) == 0 {
+		return nil
+	}
+
+	// Tables with hidden columns cannot be read with *
+	restricted := make(map[tableRef]bool)
+	for _, t := range tables {
+		key := tableRef{schema: t.schema, name: t.name}
+		if _, seen := restricted[key]; seen {
+			continue
+		}
+		columns, err := tableColumnNames(ctx, sess, t.schema, t.name)
+		if err != nil {
+			return err
+		}
+		restricted[key] = false
+		for _, col := range columns {
+			if !access.columnAllowed(t.schema, t.name, col) {
+				restricted[key] = true
+				break
+			}
+		}
+	}
+
+	candidates := func(qualifier string) []tableRef {
+		if qualifier == "" {
+			return tables
+		}
+		var matched []tableRef
+		for _, t := range refs.aliases[strings.ToLower(qualifier)] {
+			if t.schema == "" {
+				t.schema = defaultSchema
+			}
+			matched = append(matched, t)
+		}
+		if len(matched) == 0 {
+			return tables
+		}
+		return matched
+	}
+
+	for _, wildcard := range refs.wildcards {
+		for _, t := range candidates(wildcard) {
+			if restricted[tableRef{schema: t.schema, name: t.name}] {
+				return fmt.Errorf("query rejected: %s.%s has restricted columns, select the columns explicitly instead of *", t.schema, t.name)
+			}
+		}
+	}
+	for _, col := range refs.columns {
+		for _, t := range candidates(col.Table.O) {
+			if !access.columnAllowed(t.schema, t.name, col.Name.O) {
+				return fmt.Errorf("query rejected: column %s is not accessible", col.Name.O)
+			}
+		}
+	}
+	return nil
+}
+
+// checkShowAccess checks the schema or table a SHOW statement reads,
+// resolved against schema when unqualified. Lists of schemas or tables
+// cannot be filtered and are rejected when rules for them are configured,
+// and so are SHOW statements the rules cannot be applied to.
+func checkShowAccess(p *accessPolicy, show *ast.ShowStmt, schema string) error {
+	switch show.Tp {
+	case ast.ShowColumns, ast.ShowIndex, ast.ShowCreateTable, ast.ShowCreateView:
+		if show.Table.Schema.O != "" {
+			schema = show.Table.Schema.O
+		}
+		if !p.tableAllowed(schema, show.Table.Name.O) {
+			return notAccessibleError(schema, show.Table.Name.O)
+		}
+	case ast.ShowDatabases:
+		if p.hasSchemaRules() {
+			return fmt.Errorf("query rejected: use list_schemas to list schemas")
+		}
+	case ast.ShowTables, ast.ShowTableStatus, ast.ShowTriggers:
+		if !p.schemaAllowed(schema) {
+			return notAccessibleError(schema, "")
+		}
+		if len(p.allowTables) > 0 || len(p.denyTables) > 0 {
+			return fmt.Errorf("query rejected: use list_tables to list tables")
+		}
+	case ast.ShowEvents, ast.ShowCreateDatabase:
+		if !p.schemaAllowed(schema) {
+			return notAccessibleError(schema, "")
+		}
+	case ast.ShowEngines, ast.ShowCharset, ast.ShowCollation, ast.ShowVariables, ast.ShowStatus,
+		ast.ShowWarnings, ast.ShowErrors, ast.ShowPrivileges:
+		// Server settings, not about schemas
+	default:
+		if p.hasRules() {
+			return fmt.Errorf("query rejected: this SHOW statement is not allowed while schemas, tables or columns are restricted")
+		}
+	}
+	return nil
+}
+
+// tableRef is a table named in a statement.
+type tableRef struct {
+	schema string
+	name   string
+}
+
+// tableRefCollector gathers the tables, column references and wildcards of
+// a statement. References to CTEs are left out of tables, as long as the
+// CTE is in scope.
+type tableRefCollector struct {
+	tables  []tableRef
+	aliases map[string][]tableRef
+	columns []*ast.ColumnName
+	// wildcards holds the table qualifier of each *, empty for a bare *
+	wildcards []string
+	// ctes holds a scope per enclosing statement with a WITH clause
+	ctes []*cteScope
+}
+
+// cteScope holds the CTE names of a WITH clause visible so far. A CTE is in
+// scope in the CTEs defined after it, in its own definition when the clause
+// is RECURSIVE, and in the rest of the statement owning the clause.
+type cteScope struct {
+	owner     ast.Node
+	recursive bool
+	names     map[string]bool
+}
+
+func newTableRefCollector() *tableRefCollector {
+	return &tableRefCollector{aliases: make(map[string][]tableRef)}
+}
+
+// withClause returns the WITH clause of n, if it has one.
+func withClause(n ast.Node) *ast.WithClause {
+	switch node := n.(type) {
+	case *ast.SelectStmt:
+		return node.With
+	case *ast.SetOprStmt:
+		return node.With
+	case *ast.SetOprSelectList:
+		return node.With
+	case *ast.UpdateStmt:
+		return node.With
+	case *ast.DeleteStmt:
+		return node.With
+	}
+	return nil
+}
+
+// isCTE reports whether a table name refers to a CTE in scope.
+func (c *tableRefCollector) isCTE(name *ast.TableName) bool {
+	if name.Schema.O != "" {
+		return false
+	}
+	for _, scope := range c.ctes {
+		if scope.names[name.Name.L] {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *tableRefCollector) Enter(n ast.Node) (ast.Node, bool) {
+	if with := withClause(n); with != nil {
+		c.ctes = append(c.ctes, &cteScope{owner: n, recursive: with.IsRecursive, names: make(map[string]bool)})
+	}
+
+	switch node := n.(type) {
+	case *ast.CommonTableExpression:
+		if scope := c.ctes[len(c.ctes)-1]; scope.recursive {
+			scope.names[node.Name.L] = true
+		}
+	case *ast.TableSource:
+		if name, ok := node.Source.(*ast.TableName); ok {
+			ref := tableRef{schema: name.Schema.O, name: name.Name.O}
+			alias := name.Name.L
+			if node.AsName.L != "" {
+				alias = node.AsName.L
+			}
+			c.aliases[alias] = append(c.aliases[alias], ref)
+		}
+	case *ast.TableName:
+		if !c.isCTE(node) {
+			c.tables = append(c.tables, tableRef{schema: node.Schema.O, name: node.Name.O})
+		}
+	case *ast.ColumnName:
+		c.columns = append(c.columns, node)
+	case *ast.SelectField:
+		if node.WildCard != nil {
+			c.wildcards = append(c.wildcards, node.WildCard.Table.O)
+		}
+	case *ast.ShowStmt:
+		// DESCRIBE and SHOW CREATE TABLE reveal every column like SELECT *
+		if node.Tp == ast.ShowColumns || node.Tp == ast.ShowCreateTable {
+			c.wildcards = append(c.wildcards, "")
+		}
+	case *ast.SelectStmt:
+		// TABLE t reads every column like SELECT *
+		if node.Kind == ast.SelectStmtKindTable {
+			c.wildcards = append(c.wildcards, "")
+		}
+	}
+	return n, false
+}
+
+func (c *tableRefCollector) Leave(n ast.Node) (ast.Node, bool) {
+	switch node := n.(type) {
+	case *ast.CommonTableExpression:
+		c.ctes[len(c.ctes)-1].names[node.Name.L] = true
+	default:
+		if len(c.ctes) > 0 && c.ctes[len(c.ctes)-1].owner == n {
+			c.ctes = c.ctes[:len(c.ctes)-1]
+		}
+	}
+	return n, true
+}
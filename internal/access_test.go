@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// TestIdentifiersCannotEscapeQuoting checks that schema, table and column
+// names containing backticks stay single identifiers in the statements of
+// get_table_create and search_table, so that they cannot reach a table the
+// access rules deny.
+func TestIdentifiersCannotEscapeQuoting(t *testing.T) {
+	access, err := newAccessPolicy(AccessConfig{DenySchemas: []string{"hr"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []struct{ schema, table string }{
+		{"hr`.`salaries`#", "x"},
+		{"app", "x` UNION SELECT * FROM `hr`.`salaries`#"},
+	} {
+		for _, tool := range []string{"get_table_create", "search_table"} {
+			fake := &fakeDB{tableColumns: [][]driver.Value{{"name", "varchar"}, {"a`b", "text"}}}
+			ms := newTestServer(fake.open(), ReadOnlyOff)
+			ms.access = access
+
+			var request mcp.CallToolRequest
+			request.Params.Name = tool
+			request.Params.Arguments = map[string]any{"schema": name.schema, "table": name.table, "search_term": "x"}
+			if tool == "get_table_create" {
+				ms.getTableCreateHandler(context.Background(), request)
+			} else {
+				ms.searchTableHandler(context.Background(), request)
+			}
+
+			var checked int
+			for _, query := range fake.received() {
+				if !strings.HasPrefix(query, "SHOW CREATE TABLE") && !strings.HasPrefix(query, "SELECT `name`") {
+					continue
+				}
+				checked++
+				stmt, err := parseReadOnlyStatement(query)
+				if err != nil {
+					t.Errorf("%s: %q does not parse: %v", tool, query, err)
+					continue
+				}
+				refs := newTableRefCollector()
+				stmt.Accept(refs)
+				if len(refs.tables) != 1 || refs.tables[0] != (tableRef{schema: name.schema, name: name.table}) {
+					t.Errorf("%s: %q reads %v, want only %s.%s", tool, query, refs.tables, name.schema, name.table)
+				}
+			}
+			if checked != 1 {
+				t.Errorf("%s: sent %d table statements, want 1: %q", tool, checked, fake.received())
+			}
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/pingcap/tidb/pkg/parser"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Default rotation of the audit log file
+const (
+	DefaultAuditMaxSizeMB  = 100
+	DefaultAuditMaxBackups = 10
+)
+
+// maxAuditErrorLength caps the error text of an audit entry.
+const maxAuditErrorLength = 1024
+
+// Audit outcomes
+const (
+	auditOK        = "ok"
+	auditError     = "error"
+	auditCancelled = "cancelled"
+)
+
+// defaultRedactedArgs are tool arguments that carry data values.
+var defaultRedactedArgs = []string{"params", "search_term"}
+
+// sensitiveArgPattern matches argument names that look like credentials.
+var sensitiveArgPattern = regexp.MustCompile(`(?i)password|secret|token|credential|api_?key`)
+
+// auditLog writes one JSON line per tool call.
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+	// redactArgs are the arguments whose values are not logged
+	redactArgs map[string]bool
+}
+
+// newAuditLog opens the audit log named by cfg, either "stderr" or a file
+// path, or returns nil when none is set. Files are rotated by size and old
+// files pruned by count and age.
+func newAuditLog(cfg AuditConfig) (*auditLog, error) {
+	target := strings.TrimSpace(cfg.Log)
+	if target == "" {
+		return nil, nil
+	}
+
+	a := &auditLog{redactArgs: make(map[string]bool)}
+	for _, name := range append(defaultRedactedArgs, cfg.RedactArgs...) {
+		if name = strings.TrimSpace(name); name != "" {
+			a.redactArgs[name] = true
+		}
+	}
+
+	if target == "stderr" {
+		a.w = os.Stderr
+		return a, nil
+	}
+
+	logger := &lumberjack.Logger{
+		Filename:   target,
+		MaxSize:    cfg.MaxSizeMB,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAgeDays,
+	}
+	// Fail at startup rather than on the first tool call
+	if _, err := logger.Write(nil); err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", target, err)
+	}
+	a.w = logger
+	return a, nil
+}
+
+func (a *auditLog) Close() error {
+	if c, ok := a.w.(io.Closer); ok && a.w != os.Stderr {
+		return c.Close()
+	}
+	return nil
+}
+
+// auditEntry is a line of the audit log.
+type auditEntry struct {
+	Time       time.Time              `json:"time"`
+	Tool       string                 `json:"tool"`
+	Arguments  map[string]interface{} `json:"arguments,omitempty"`
+	SQL        string                 `json:"sql,omitempty"`
+	DurationMS float64                `json:"duration_ms"`
+	Rows       *int                   `json:"rows,omitempty"`
+	Outcome    string                 `json:"outcome"`
+	Error      string                 `json:"error,omitempty"`
+	Transport  string                 `json:"transport,omitempty"`
+	SessionID  string                 `json:"session_id,omitempty"`
+	RequestID  interface{}            `json:"request_id,omitempty"`
+	Client     string                 `json:"client,omitempty"`
+	Identity   string                 `json:"identity,omitempty"`
+	DBUser     string                 `json:"db_user,omitempty"`
+
+	mu sync.Mutex
+}
+
+type auditEntryKey struct{}
+
+// auditSQL records the SQL a tool call runs, normalized so that literal
+// values are not logged.
+func auditSQL(ctx context.Context, query string) {
+	if e, ok := ctx.Value(auditEntryKey{}).(*auditEntry); ok {
+		e.mu.Lock()
+		e.SQL = normalizeSQL(query)
+		e.mu.Unlock()
+	}
+}
+
+// auditRows records the number of rows a tool call returns.
+func auditRows(ctx context.Context, n int) {
+	if e, ok := ctx.Value(auditEntryKey{}).(*auditEntry); ok {
+		e.mu.Lock()
+		e.Rows = &n
+		e.mu.Unlock()
+	}
+}
+
+// normalizeSQL replaces literals with ? and collapses whitespace.
+func normalizeSQL(query string) string {
+	return parser.Normalize(query, "ON")
+}
+
+// redactArguments copies args without the values of sensitive arguments.
+// The query argument is left out, it is logged normalized instead.
+func (a *auditLog) redactArguments(args map[string]any) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(args))
+	for k, v := range args {
+		switch {
+		case k == "query":
+			continue
+		case a.redactArgs[k] || sensitiveArgPattern.MatchString(k):
+			redacted[k] = redactedText
+		default:
+			redacted[k] = v
+		}
+	}
+	return redacted
+}
+
+func (a *auditLog) write(e *auditEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.w.Write(append(line, '\n'))
+}
+
+// middleware records every tool call in the audit log.
+func (a *auditLog) middleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := request.GetArguments()
+		entry := &auditEntry{
+			Time:      time.Now().UTC(),
+			Tool:      request.Params.Name,
+			Arguments: a.redactArguments(args),
+			RequestID: requestIDFromContext(ctx),
+		}
+		if id := identityFromContext(ctx); id != nil {
+			entry.Identity = id.name
+		}
+		if u := dbUserFromContext(ctx); u != nil {
+			entry.DBUser = u.user
+		}
+		if query, ok := args["query"].(string); ok {
+			entry.SQL = normalizeSQL(query)
+		}
+		if session := server.ClientSessionFromContext(ctx); session != nil {
+			// The stdio transport has a single session with a fixed ID
+			entry.SessionID = session.SessionID()
+			entry.Transport = "http"
+			if entry.SessionID == "stdio" {
+				entry.Transport = "stdio"
+				entry.SessionID = ""
+			}
+			if withInfo, ok := session.(server.SessionWithClientInfo); ok {
+				if info := withInfo.GetClientInfo(); info.Name != "" {
+					entry.Client = strings.TrimSpace(info.Name + " " + info.Version)
+				}
+			}
+		}
+
+		start := time.Now()
+		result, err := next(context.WithValue(ctx, auditEntryKey{}, entry), request)
+
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		entry.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+		switch {
+		case ctx.Err() == context.Canceled:
+			entry.Outcome = auditCancelled
+		case err != nil:
+			entry.Outcome = auditError
+			entry.Error = err.Error()
+		case result != nil && result.IsError:
+			entry.Outcome = auditError
+			for _, content := range result.Content {
+				if t, ok := mcp.AsTextContent(content); ok {
+					entry.Error = t.Text
+					break
+				}
+			}
+		default:
+			entry.Outcome = auditOK
+		}
+		if len(entry.Error) > maxAuditErrorLength {
+			entry.Error = truncateUTF8(entry.Error, maxAuditErrorLength) + "..."
+		}
+		a.write(entry)
+
+		return result, err
+	}
+}
@@ -0,0 +1,378 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// JSON-RPC error codes of rejected HTTP requests
+const (
+	rpcUnauthorized = -32001
+	rpcForbidden    = -32003
+)
+
+// Authentication failures; other errors mean the credentials were
+// rejected
+var (
+	errMissingCredentials = errors.New("missing credentials")
+	errInvalidCredentials = errors.New("invalid credentials")
+	// errInsufficientScope rejects valid credentials that grant nothing
+	errInsufficientScope = errors.New("insufficient scope")
+)
+
+// identity is an authenticated HTTP caller and what it may use.
+type identity struct {
+	name string
+	// tools, schemas and connections restrict the caller to matching
+	// names; empty allows all
+	tools       []string
+	schemas     []namePattern
+	connections []string
+}
+
+// allowsTool reports whether the caller may call the tool.
+func (id *identity) allowsTool(tool string) bool {
+	return id == nil || matchesAny(id.tools, tool)
+}
+
+// allowsConnection reports whether the caller may use the connection.
+func (id *identity) allowsConnection(name string) bool {
+	return id == nil || matchesAny(id.connections, name)
+}
+
+// matchesAny reports whether name matches one of patterns, or patterns is
+// empty.
+func matchesAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if globMatch(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+// identityFromContext returns the authenticated caller of a tool call, or
+// nil when the transport does not authenticate.
+func identityFromContext(ctx context.Context) *identity {
+	id, _ := ctx.Value(identityKey{}).(*identity)
+	return id
+}
+
+// identityName returns the name of the caller of a tool call, or "".
+func identityName(ctx context.Context) string {
+	if id := identityFromContext(ctx); id != nil {
+		return id.name
+	}
+	return ""
+}
+
+// authenticator checks one kind of credentials. It returns nil without an
+// error when the request carries none of its kind.
+type authenticator interface {
+	authenticate(r *http.Request) (*identity, error)
+}
+
+// apiKey is a static key, stored as its SHA-256 hash.
+type apiKey struct {
+	hash     [sha256.Size]byte
+	identity *identity
+}
+
+// apiKeyAuth accepts static API keys, sent as a bearer token or in the
+// X-API-Key header.
+type apiKeyAuth struct {
+	keys []apiKey
+}
+
+// newAPIKeyAuth returns the authenticator of the configured keys, or nil
+// when there are none.
+func newAPIKeyAuth(keys map[string]*APIKeyConfig) (*apiKeyAuth, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	a := &apiKeyAuth{}
+	for _, name := range names {
+		cfg := keys[name]
+		hash, err := parseKeyHash(cfg.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hash of API key %s: %w", name, err)
+		}
+		schemas, err := parseNamePatterns(cfg.Schemas, 1)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schemas of API key %s: %w", name, err)
+		}
+		a.keys = append(a.keys, apiKey{hash: hash, identity: &identity{
+			name:        name,
+			tools:       lowerAll(cfg.Tools),
+			schemas:     schemas,
+			connections: lowerAll(cfg.Connections),
+		}})
+	}
+	return a, nil
+}
+
+// parseKeyHash parses a hex SHA-256 hash, optionally prefixed with sha256:.
+func parseKeyHash(s string) ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+	b, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "sha256:"))
+	if err != nil || len(b) != sha256.Size {
+		return hash, fmt.Errorf("expected sha256: and 64 hex digits")
+	}
+	copy(hash[:], b)
+	return hash, nil
+}
+
+func lowerAll(items []string) []string {
+	lower := make([]string, len(items))
+	for i, item := range items {
+		lower[i] = strings.ToLower(strings.TrimSpace(item))
+	}
+	return lower
+}
+
+func (a *apiKeyAuth) authenticate(r *http.Request) (*identity, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = bearerToken(r)
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	hash := sha256.Sum256([]byte(key))
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(hash[:], k.hash[:]) == 1 {
+			return k.identity, nil
+		}
+	}
+	// Another authenticator may accept the bearer token
+	return nil, nil
+}
+
+// bearerToken returns the token of an Authorization: Bearer header.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// httpAuth authenticates every request to the MCP endpoint and checks tool
+// calls against the caller's scopes.
+type httpAuth struct {
+	authenticators []authenticator
+	// primary is the connection of tool calls that name none
+	primary string
+	// oauth is the JWT authenticator, nil without OAuth
+	oauth *jwtAuth
+}
+
+// newHTTPAuth returns the HTTP authentication of cfg, or nil when no
+// credentials are configured. Client certificates are accepted when
+// httpCfg verifies them.
+func newHTTPAuth(cfg AuthConfig, httpCfg HTTPConfig, primary string) (*httpAuth, error) {
+	a := &httpAuth{primary: primary}
+
+	keys, err := newAPIKeyAuth(cfg.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+	if keys != nil {
+		a.authenticators = append(a.authenticators, keys)
+	}
+	if cfg.OAuth.enabled() {
+		a.oauth, err = newJWTAuth(cfg.OAuth)
+		if err != nil {
+			return nil, err
+		}
+		// API keys are tried first, any other bearer token must be a JWT
+		a.authenticators = append(a.authenticators, a.oauth)
+	}
+	if len(cfg.ClientCerts) > 0 && httpCfg.ClientCA == "" {
+		return nil, fmt.Errorf("auth.client_certs needs http.client_ca to verify client certificates")
+	}
+	if httpCfg.ClientCA != "" {
+		certs, err := newClientCertAuth(cfg.ClientCerts)
+		if err != nil {
+			return nil, err
+		}
+		// Last, so that credentials sent along with a certificate take
+		// precedence
+		a.authenticators = append(a.authenticators, certs)
+	}
+
+	if len(a.authenticators) == 0 {
+		return nil, nil
+	}
+	return a, nil
+}
+
+func (a *httpAuth) authenticate(r *http.Request) (*identity, error) {
+	for _, auth := range a.authenticators {
+		id, err := auth.authenticate(r)
+		if err != nil || id != nil {
+			return id, err
+		}
+	}
+	if r.Header.Get("Authorization") != "" || r.Header.Get("X-API-Key") != "" {
+		return nil, errInvalidCredentials
+	}
+	return nil, errMissingCredentials
+}
+
+// middleware rejects unauthenticated requests with 401, and tokens that
+// grant nothing and tool calls outside the caller's scopes with 403. The
+// caller is passed on in the request context.
+func (a *httpAuth) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.authenticate(r)
+		if errors.Is(err, errInsufficientScope) {
+			w.Header().Set("WWW-Authenticate", a.challenge(err))
+			writeHTTPError(w, http.StatusForbidden, nil, rpcForbidden, "forbidden: "+err.Error())
+			return
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", a.challenge(err))
+			writeHTTPError(w, http.StatusUnauthorized, nil, rpcUnauthorized, "unauthorized: "+err.Error())
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeHTTPError(w, http.StatusBadRequest, nil, mcp.PARSE_ERROR, "failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			if requestID, err := a.checkToolCallScope(id, body); err != nil {
+				writeHTTPError(w, http.StatusForbidden, requestID, rpcForbidden, "forbidden: "+err.Error())
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+// challenge returns the WWW-Authenticate header of a rejected request.
+// With OAuth it points clients at the protected resource metadata.
+func (a *httpAuth) challenge(err error) string {
+	challenge := `Bearer realm="mysql-mcp"`
+	switch {
+	case errors.Is(err, errInsufficientScope):
+		challenge += `, error="insufficient_scope"`
+	case !errors.Is(err, errMissingCredentials):
+		challenge += `, error="invalid_token"`
+	}
+	if a.oauth != nil {
+		challenge += fmt.Sprintf(`, resource_metadata="%s"`, a.oauth.metadataURL())
+	}
+	return challenge
+}
+
+// checkToolCallScope checks a tools/call request body against the scopes
+// of id and returns the request ID with the reason it is not allowed.
+// Other messages are left to the MCP server.
+func (a *httpAuth) checkToolCallScope(id *identity, body []byte) (any, error) {
+	var msg struct {
+		ID     any             `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil || msg.Method != string(mcp.MethodToolsCall) {
+		return nil, nil
+	}
+	// Arguments that are not an object are accepted by the MCP server and
+	// treated as none, so they are checked the same way
+	var params struct {
+		Name      string `json:"name"`
+		Arguments any    `json:"arguments"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return msg.ID, fmt.Errorf("malformed tools/call params")
+	}
+	args, _ := params.Arguments.(map[string]any)
+	if err := checkScope(id, params.Name, args, a.primary); err != nil {
+		return msg.ID, err
+	}
+	return nil, nil
+}
+
+// checkScope returns why id may not call tool with args, if it may not.
+// Calls without a connection argument use primary.
+func checkScope(id *identity, tool string, args map[string]any, primary string) error {
+	if id == nil {
+		return nil
+	}
+	if !id.allowsTool(tool) {
+		return fmt.Errorf("%s may not call %s", id.name, tool)
+	}
+	conn, _ := args["connection"].(string)
+	conn = strings.TrimSpace(conn)
+	if conn == "" {
+		if tool == "fetch_more" || tool == "list_connections" {
+			// Continues on the connection of its cursor, or lists the
+			// allowed connections
+			return nil
+		}
+		conn = primary
+	}
+	if !id.allowsConnection(conn) {
+		return fmt.Errorf("%s may not use connection %s", id.name, conn)
+	}
+	return nil
+}
+
+// scopeMiddleware refuses tool calls outside the scopes of the HTTP caller.
+// The HTTP middleware answers them with 403 before they reach the MCP
+// server; this checks the arguments as the handlers see them.
+func (ms *MySQLServer) scopeMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if err := checkScope(identityFromContext(ctx), request.Params.Name, request.GetArguments(), ms.primary); err != nil {
+			return nil, fmt.Errorf("forbidden: %w", err)
+		}
+		return next(ctx, request)
+	}
+}
+
+// writeHTTPError writes a JSON-RPC error response with an HTTP status.
+func writeHTTPError(w http.ResponseWriter, status int, id any, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(mcp.JSONRPCError{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      mcp.NewRequestId(id),
+		Error: struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Data    any    `json:"data,omitempty"`
+		}{Code: code, Message: message},
+	})
+}
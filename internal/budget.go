@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Default response budget, both can be disabled with 0
+const (
+	DefaultMaxResponseBytes = 256 * 1024
+	DefaultMaxCellBytes     = 4096
+)
+
+// rowBudgetShare is the part of the response budget available to rows; the
+// rest is left for column metadata and other result fields.
+const rowBudgetShare = 0.9
+
+// responseBudget bounds the size of tool results.
+type responseBudget struct {
+	// maxResponseBytes caps the size of a whole tool result
+	maxResponseBytes int64
+	// maxCellBytes caps the size of a single value
+	maxCellBytes int64
+}
+
+// rowBytes is the number of bytes rows may use, or 0 for no limit.
+func (b responseBudget) rowBytes() int64 {
+	return int64(float64(b.maxResponseBytes) * rowBudgetShare)
+}
+
+// truncateCell shortens an encoded value longer than maxCellBytes. Text and
+// JSON become {"$truncated": prefix, "$length": n} and binary values
+// {"$truncated": base64 prefix, "$encoding": "base64", "$length": n}, where
+// n is the original length in bytes.
+func (b responseBudget) truncateCell(v interface{}) (interface{}, bool) {
+	max := int(b.maxCellBytes)
+	if max <= 0 {
+		return v, false
+	}
+
+	switch val := v.(type) {
+	case string:
+		if len(val) > max {
+			return map[string]interface{}{"$truncated": truncateUTF8(val, max), "$length": len(val)}, true
+		}
+	case json.RawMessage:
+		if len(val) > max {
+			return map[string]interface{}{"$truncated": truncateUTF8(string(val), max), "$length": len(val)}, true
+		}
+	case map[string]string:
+		// Short binary values are hex and never exceed the cell budget
+		if s, ok := val["$base64"]; ok && len(s) > max {
+			return map[string]interface{}{
+				"$truncated": s[:max/4*4],
+				"$encoding":  "base64",
+				"$length":    base64.StdEncoding.DecodedLen(len(s)) - paddingBytes(s),
+			}, true
+		}
+	}
+	return v, false
+}
+
+func paddingBytes(s string) int {
+	n := 0
+	for i := len(s) - 1; i >= 0 && s[i] == '='; i-- {
+		n++
+	}
+	return n
+}
+
+// truncateUTF8 returns the longest prefix of s of at most max bytes that
+// does not split a character.
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// page splits rs into the rows returned in one response, at most limit rows
+// within the row budget, and the rows left over. The first row is always
+// returned, even when it alone is over budget.
+func (b responseBudget) page(rs *resultSet, limit int) (*resultSet, [][]interface{}, error) {
+	n := len(rs.rows)
+	if n > limit {
+		n = limit
+	}
+
+	overBudget := false
+	if rowBudget := b.rowBytes(); rowBudget > 0 {
+		var size int64
+		for i, row := range rs.rows[:n] {
+			encoded, err := json.Marshal(row)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to encode row: %w", err)
+			}
+			if size += int64(len(encoded)); size > rowBudget && i > 0 {
+				n = i
+				overBudget = true
+				break
+			}
+		}
+	}
+
+	page := &resultSet{
+		columnTypes: rs.columnTypes,
+		rows:        rs.rows[:n],
+		truncated:   rs.truncated || n < len(rs.rows),
+		overBudget:  overBudget,
+		offset:      rs.offset,
+		masked:      rs.masked,
+	}
+	return page, rs.rows[n:], nil
+}
+
+// truncatedCells counts the values cut off at the cell budget.
+func truncatedCells(rows [][]interface{}) int {
+	n := 0
+	for _, row := range rows {
+		for _, v := range row {
+			if m, ok := v.(map[string]interface{}); ok {
+				if _, ok := m["$truncated"]; ok {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// truncationHint explains how to get the rest of a truncated result.
+// withCursor reports whether the result carries a cursor.
+func truncationHint(rs *resultSet, budget responseBudget, withCursor bool) string {
+	switch {
+	case rs.truncated && withCursor:
+		return "More rows are available. Call fetch_more with the cursor to continue"
+	case rs.overBudget:
+		return fmt.Sprintf("Stopped after %d rows to stay within the %d byte response budget. Select fewer or narrower columns, or continue from OFFSET %d", len(rs.rows), budget.maxResponseBytes, rs.offset+len(rs.rows))
+	case rs.truncated:
+		return fmt.Sprintf("More rows are available. Continue with LIMIT/OFFSET, e.g. OFFSET %d", rs.offset+len(rs.rows))
+	}
+	if cells := truncatedCells(rs.rows); cells > 0 {
+		return fmt.Sprintf("%d values longer than %d bytes were cut off; select them with SUBSTRING() to read a specific part", cells, budget.maxCellBytes)
+	}
+	return ""
+}
+
+// budgetMiddleware replaces any tool result that still exceeds the response
+// budget with a truncated preview, so no handler can flood the client.
+func (ms *MySQLServer) budgetMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result, err := next(ctx, request)
+		max := int(ms.budget.maxResponseBytes)
+		if err != nil || result == nil || max <= 0 {
+			return result, err
+		}
+
+		var text strings.Builder
+		for _, content := range result.Content {
+			if t, ok := mcp.AsTextContent(content); ok {
+				text.WriteString(t.Text)
+			}
+		}
+		size := text.Len()
+		if size <= max {
+			return result, nil
+		}
+
+		preview, err := json.Marshal(map[string]interface{}{
+			"truncated": true,
+			"length":    size,
+			"preview":   truncateUTF8(text.String(), max/2),
+			"hint":      fmt.Sprintf("The result exceeded the %d byte response budget. Request fewer rows, fewer columns or a smaller page", max),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal result: %w", err)
+		}
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{mcp.NewTextContent(string(preview))},
+			IsError: result.IsError,
+		}, nil
+	}
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// toolCalls maps in-flight tool calls to their cancel functions so that a
+// notifications/cancelled message from the client aborts the database query.
+type toolCalls struct {
+	// requestIDs carries the JSON-RPC request ID from the before-call hook to
+	// the handler middleware; both receive the same request context.
+	requestIDs sync.Map
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func newToolCalls() *toolCalls {
+	return &toolCalls{cancels: make(map[string]context.CancelFunc)}
+}
+
+type requestIDKey struct{}
+
+// requestIDFromContext returns the JSON-RPC request ID of a tool call, or
+// nil outside the cancellation middleware.
+func requestIDFromContext(ctx context.Context) any {
+	return ctx.Value(requestIDKey{})
+}
+
+// callKey identifies a request within its client session. The hooks pass
+// the request ID as an mcp.RequestId, while a notification carries it as a
+// decoded JSON number or string; both are keyed by the mcp.RequestId form.
+func callKey(ctx context.Context, requestID any) string {
+	sessionID := ""
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		sessionID = session.SessionID()
+	}
+	id, ok := requestID.(mcp.RequestId)
+	if !ok {
+		if n, isNumber := requestID.(float64); isNumber && n == float64(int64(n)) {
+			requestID = int64(n)
+		}
+		id = mcp.NewRequestId(requestID)
+	}
+	return fmt.Sprintf("%s/%s", sessionID, id.String())
+}
+
+// registerHooks wires request tracking and cancellation into an MCP server.
+func (tc *toolCalls) registerHooks(hooks *server.Hooks) {
+	hooks.AddBeforeCallTool(func(ctx context.Context, id any, message *mcp.CallToolRequest) {
+		tc.requestIDs.Store(ctx, id)
+	})
+	hooks.AddAfterCallTool(func(ctx context.Context, id any, message *mcp.CallToolRequest, result *mcp.CallToolResult) {
+		tc.requestIDs.Delete(ctx)
+	})
+	hooks.AddOnError(func(ctx context.Context, id any, method mcp.MCPMethod, message any, err error) {
+		if method == mcp.MethodToolsCall {
+			tc.requestIDs.Delete(ctx)
+		}
+	})
+}
+
+// middleware makes every tool call cancellable by request ID.
+func (tc *toolCalls) middleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		id, ok := tc.requestIDs.Load(ctx)
+		if !ok {
+			return next(ctx, request)
+		}
+
+		key := callKey(ctx, id)
+		ctx, cancel := context.WithCancel(context.WithValue(ctx, requestIDKey{}, id))
+		defer cancel()
+
+		tc.mu.Lock()
+		tc.cancels[key] = cancel
+		tc.mu.Unlock()
+
+		defer func() {
+			tc.mu.Lock()
+			delete(tc.cancels, key)
+			tc.mu.Unlock()
+		}()
+
+		return next(ctx, request)
+	}
+}
+
+// handleCancelled handles notifications/cancelled from the client.
+func (tc *toolCalls) handleCancelled(ctx context.Context, notification mcp.JSONRPCNotification) {
+	requestID, ok := notification.Params.AdditionalFields["requestId"]
+	if !ok {
+		return
+	}
+
+	tc.mu.Lock()
+	cancel, ok := tc.cancels[callKey(ctx, requestID)]
+	tc.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
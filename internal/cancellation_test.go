@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// TestCancelledNotificationCancelsCall starts a tool call, sends
+// notifications/cancelled for its request ID and checks that the context of
+// the call is cancelled.
+func TestCancelledNotificationCancelsCall(t *testing.T) {
+	for _, id := range []string{`7`, `"call-7"`} {
+		tc := newToolCalls()
+		hooks := &server.Hooks{}
+		tc.registerHooks(hooks)
+		s := server.NewMCPServer("test", "1.0.0", server.WithHooks(hooks), server.WithToolHandlerMiddleware(tc.middleware))
+		s.AddNotificationHandler("notifications/cancelled", tc.handleCancelled)
+
+		started := make(chan struct{})
+		cancelled := make(chan struct{})
+		s.AddTool(mcp.NewTool("wait"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			close(started)
+			select {
+			case <-ctx.Done():
+				close(cancelled)
+			case <-time.After(5 * time.Second):
+			}
+			return mcp.NewToolResultText("done"), nil
+		})
+
+		ctx := context.Background()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.HandleMessage(ctx, []byte(`{"jsonrpc":"2.0","id":`+id+`,"method":"tools/call","params":{"name":"wait"}}`))
+		}()
+		<-started
+		s.HandleMessage(ctx, []byte(`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":`+id+`}}`))
+
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Errorf("request %s: the call was not cancelled", id)
+		}
+		<-done
+	}
+}
+
+// TestCallKeyMatchesNotificationIDs checks that a request ID passed as an
+// mcp.RequestId and the same ID decoded from a notification give one key.
+func TestCallKeyMatchesNotificationIDs(t *testing.T) {
+	ctx := context.Background()
+	for _, ids := range []struct {
+		id           mcp.RequestId
+		notification any
+	}{
+		{mcp.NewRequestId(int64(7)), float64(7)},
+		{mcp.NewRequestId(float64(7)), float64(7)},
+		{mcp.NewRequestId("call-7"), "call-7"},
+	} {
+		if got, want := callKey(ctx, ids.notification), callKey(ctx, ids.id); got != want {
+			t.Errorf("notification key %q, want %q", got, want)
+		}
+		if got, want := callKey(ctx, ids.id.Value()), callKey(ctx, ids.id); got != want {
+			t.Errorf("raw ID key %q, want %q", got, want)
+		}
+	}
+}
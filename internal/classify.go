@@ -0,0 +1,420 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Classification defaults of classify_columns
+const (
+	DefaultClassifySampleSize    = 100
+	MaxClassifySampleSize        = 1000
+	DefaultClassifyMinConfidence = 0.5
+)
+
+// maxSampleValueLength is the number of characters of a value sampled.
+const maxSampleValueLength = 2048
+
+// piiCategory is a kind of sensitive data classify_columns looks for.
+type piiCategory struct {
+	name string
+	// namePattern matches column names of this kind
+	namePattern *regexp.Regexp
+	// nameWeight is the confidence a matching name alone gives
+	nameWeight float64
+	// value reports whether a sampled value is of this kind; nil for
+	// categories only recognised by name
+	value func(string) bool
+	// types are the data types the category is stored as, nil for text
+	types []string
+	// strategy is the suggested masking strategy
+	strategy string
+}
+
+var piiCategories = []piiCategory{
+	{
+		name:        "email",
+		namePattern: regexp.MustCompile(`(^|_)e_?mail(_|$)|email`),
+		nameWeight:  0.6,
+		value:       isEmail,
+		strategy:    MaskPartial,
+	},
+	{
+		name:        "phone",
+		namePattern: regexp.MustCompile(`phone|mobile|(^|_)(cell|fax|tel|msisdn)(_|$)`),
+		nameWeight:  0.6,
+		value:       isPhoneNumber,
+		types:       []string{"bigint"},
+		strategy:    MaskPartial,
+	},
+	{
+		name:        "iban",
+		namePattern: regexp.MustCompile(`iban|bank_?account|account_?(number|no)`),
+		nameWeight:  0.6,
+		value:       isIBAN,
+		strategy:    MaskPartial,
+	},
+	{
+		name:        "credit_card",
+		namePattern: regexp.MustCompile(`(credit_?)?card_?(num|no|number)|(^|_)(cc|pan)(_?(num|number))?$`),
+		nameWeight:  0.6,
+		value:       isCardNumber,
+		types:       []string{"bigint", "decimal"},
+		strategy:    MaskPartial,
+	},
+	{
+		name:        "ip_address",
+		namePattern: regexp.MustCompile(`(^|_)ip(_?(addr|address|v4|v6))?(_|$)|remote_?addr`),
+		nameWeight:  0.5,
+		value:       isIPAddress,
+		types:       []string{"int", "varbinary", "binary"},
+		strategy:    MaskHash,
+	},
+	{
+		name:        "jwt",
+		namePattern: regexp.MustCompile(`jwt|(^|_)(id|access|refresh|bearer)_?token(_|$)`),
+		nameWeight:  0.5,
+		value:       isJWT,
+		strategy:    MaskRedact,
+	},
+	{
+		name:        "national_id",
+		namePattern: regexp.MustCompile(`(^|_)(ssn|nin|sin|tin)(_|$)|social_?security|national_?id|passport|tax_?id|personnummer`),
+		nameWeight:  0.7,
+		strategy:    MaskRedact,
+	},
+	{
+		name:        "secret",
+		namePattern: regexp.MustCompile(`password|passwd|(^|_)pwd(_|$)|secret|api_?key|(^|_)salt(_|$)|(^|_)token(_|$)`),
+		nameWeight:  0.7,
+		strategy:    MaskRedact,
+	},
+	{
+		name:        "person_name",
+		namePattern: regexp.MustCompile(`(first|last|middle|full|given|family|maiden)_?name|surname`),
+		nameWeight:  0.6,
+		strategy:    MaskHash,
+	},
+	{
+		name:        "address",
+		namePattern: regexp.MustCompile(`street|(^|_)address(_?line)?\d*(_|$)|postal_?code|(^|_)zip(_?code)?(_|$)|postcode`),
+		nameWeight:  0.5,
+		strategy:    MaskRedact,
+	},
+	{
+		name:        "birth_date",
+		namePattern: regexp.MustCompile(`birth|(^|_)dob(_|$)`),
+		nameWeight:  0.6,
+		types:       []string{"date", "datetime", "timestamp"},
+		strategy:    MaskRedact,
+	},
+}
+
+var (
+	emailPattern = regexp.MustCompile(`^[A-Za-z0-9._%+'-]+@[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}$`)
+	phonePattern = regexp.MustCompile(`^\+?[0-9 ().-]+$`)
+	ibanPattern  = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]{11,30}$`)
+	cardPattern  = regexp.MustCompile(`^[0-9][0-9 -]{11,21}[0-9]$`)
+	jwtPattern   = regexp.MustCompile(`^eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*$`)
+)
+
+func isEmail(s string) bool {
+	return emailPattern.MatchString(s)
+}
+
+// isPhoneNumber accepts 7 to 15 digits written with a leading + or with
+// separators; plain digit strings are more often ids than phone numbers.
+func isPhoneNumber(s string) bool {
+	if !phonePattern.MatchString(s) {
+		return false
+	}
+	digits := countDigits(s)
+	if digits < 7 || digits > 15 {
+		return false
+	}
+	return strings.HasPrefix(s, "+") || digits < len(s)
+}
+
+// isIBAN checks the format and the ISO 7064 mod 97 check digits.
+func isIBAN(s string) bool {
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	if !ibanPattern.MatchString(s) {
+		return false
+	}
+	var digits strings.Builder
+	for _, r := range s[4:] + s[:4] {
+		if r >= 'A' && r <= 'Z' {
+			fmt.Fprintf(&digits, "%d", r-'A'+10)
+		} else {
+			digits.WriteRune(r)
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// isCardNumber checks for 13 to 19 digits passing the Luhn check.
+func isCardNumber(s string) bool {
+	if !cardPattern.MatchString(s) {
+		return false
+	}
+	s = strings.NewReplacer(" ", "", "-", "").Replace(s)
+	if len(s) < 13 || len(s) > 19 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < len(s); i++ {
+		d := int(s[len(s)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+func isIPAddress(s string) bool {
+	return strings.ContainsAny(s, ".:") && net.ParseIP(s) != nil
+}
+
+// isJWT checks for three base64url segments with a JSON header naming the
+// signing algorithm.
+func isJWT(s string) bool {
+	if !jwtPattern.MatchString(s) {
+		return false
+	}
+	header, err := base64.RawURLEncoding.DecodeString(s[:strings.Index(s, ".")])
+	return err == nil && strings.Contains(string(header), `"alg"`)
+}
+
+func countDigits(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	return n
+}
+
+// columnClassification is the most likely category of a column.
+type columnClassification struct {
+	Column     string  `json:"column"`
+	Type       string  `json:"type"`
+	Category   string  `json:"category"`
+	Confidence float64 `json:"confidence"`
+	// Signals that led to the classification
+	NameMatch bool `json:"name_match"`
+	Sampled   int  `json:"sampled"`
+	Matched   int  `json:"matched"`
+	// Strategy is the suggested masking strategy
+	Strategy string `json:"strategy"`
+	// Masked is the strategy the column is masked with already, if any
+	Masked string `json:"masked,omitempty"`
+}
+
+// classifyColumn scores every category for a column and returns the best,
+// or nil if none applies. values are the non-NULL sampled text values.
+//
+// A matching name and matching values are independent signals combined as
+// 1 - (1-name)(1-values). The value signal is the share of matching values,
+// discounted for small samples; a name is discounted when the data type does
+// not fit the category or when enough values were sampled and none matched.
+func classifyColumn(col tableColumn, values []string) *columnClassification {
+	name := strings.ToLower(col.name)
+	dataType := strings.ToLower(col.dataType)
+	text := isSearchableType(dataType)
+
+	var best *columnClassification
+	for _, cat := range piiCategories {
+		nameMatch := cat.namePattern.MatchString(name)
+
+		nameScore := 0.0
+		if nameMatch {
+			nameScore = cat.nameWeight
+			typeFits := text && cat.types == nil
+			for _, t := range cat.types {
+				if strings.Contains(dataType, t) || text {
+					typeFits = true
+				}
+			}
+			if !typeFits {
+				nameScore /= 2
+			}
+		}
+
+		matched, valueScore := 0, 0.0
+		if cat.value != nil && len(values) > 0 {
+			for _, v := range values {
+				if cat.value(strings.TrimSpace(v)) {
+					matched++
+				}
+			}
+			// The share of matches, less certain for few values
+			valueScore = float64(matched) / float64(len(values)+2)
+			if matched == 0 && len(values) >= 5 {
+				nameScore /= 2
+			}
+		}
+
+		confidence := 1 - (1-nameScore)*(1-valueScore)
+		if confidence == 0 || (best != nil && confidence <= best.Confidence) {
+			continue
+		}
+		best = &columnClassification{
+			Column:     col.name,
+			Type:       col.columnType,
+			Category:   cat.name,
+			Confidence: math.Round(confidence*100) / 100,
+			NameMatch:  nameMatch,
+			Sampled:    len(values),
+			Matched:    matched,
+			Strategy:   cat.strategy,
+		}
+	}
+	return best
+}
+
+// sampleColumns reads up to limit rows of the text columns of a table,
+// returning the non-NULL values of each column.
+func sampleColumns(ctx context.Context, q queryer, schema, table string, columns []tableColumn, limit int) (map[string][]string, error) {
+	var names []string
+	for _, col := range columns {
+		if isSearchableType(col.dataType) {
+			// Long values are cut off, the patterns only need a prefix
+			names = append(names, fmt.Sprintf("LEFT(%s, %d) AS %s", quoteIdentifier(col.name), maxSampleValueLength, quoteIdentifier(col.name)))
+		}
+	}
+	samples := make(map[string][]string)
+	if len(names) == 0 {
+		return samples, nil
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s.%s LIMIT %d", strings.Join(names, ", "), quoteIdentifier(schema), quoteIdentifier(table), limit)
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sample table: %w", err)
+	}
+	defer rows.Close()
+
+	resultColumns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	values := make([]sql.NullString, len(resultColumns))
+	valuePtrs := make([]interface{}, len(resultColumns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		for i, v := range values {
+			if v.Valid && v.String != "" {
+				samples[resultColumns[i]] = append(samples[resultColumns[i]], v.String)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return samples, nil
+}
+
+func (ms *MySQLServer) classifyColumnsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	access := ms.accessFor(ctx)
+	schema, ok := args["schema"].(string)
+	if !ok || schema == "" {
+		return nil, fmt.Errorf("schema parameter is required")
+	}
+
+	table, ok := args["table"].(string)
+	if !ok || table == "" {
+		return nil, fmt.Errorf("table parameter is required")
+	}
+
+	sampleSize := getIntFromArgs(args, "sample_size", DefaultClassifySampleSize)
+	if sampleSize < 0 {
+		sampleSize = 0
+	}
+	if sampleSize > MaxClassifySampleSize {
+		sampleSize = MaxClassifySampleSize
+	}
+
+	minConfidence := DefaultClassifyMinConfidence
+	if v, ok := args["min_confidence"].(float64); ok {
+		minConfidence = v
+	}
+
+	if !access.tableAllowed(schema, table) {
+		return nil, notAccessibleError(schema, table)
+	}
+
+	conn, err := ms.getConnectionFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, sess, err := ms.beginSession(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	columns, err := ms.tableColumns(ctx, sess, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, notAccessibleError(schema, table)
+	}
+
+	samples := make(map[string][]string)
+	if sampleSize > 0 {
+		if samples, err = sampleColumns(ctx, sess, schema, table, columns, sampleSize); err != nil {
+			return nil, err
+		}
+	}
+
+	classified := []*columnClassification{}
+	var maskPatterns []string
+	for _, col := range columns {
+		c := classifyColumn(col, samples[col.name])
+		if c == nil || c.Confidence < minConfidence {
+			continue
+		}
+		if ms.masking.enabled() {
+			c.Masked = ms.masking.strategyFor(schema, table, col.name, col.comment)
+		}
+		classified = append(classified, c)
+		maskPatterns = append(maskPatterns, fmt.Sprintf("%s.%s.%s:%s", schema, table, col.name, c.Strategy))
+	}
+	sort.SliceStable(classified, func(i, j int) bool {
+		return classified[i].Confidence > classified[j].Confidence
+	})
+
+	// Sampled values are only used for scoring and never returned
+	result := map[string]interface{}{
+		"schema":         schema,
+		"table":          table,
+		"sample_size":    sampleSize,
+		"min_confidence": minConfidence,
+		"columns":        classified,
+		"mask_columns":   strings.Join(maskPatterns, ","),
+	}
+	return jsonResult(result)
+}
@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/pingcap/tidb/pkg/parser/ast"
+)
+
+// Row formats of execute_query results
+const (
+	// RowFormatObject returns each row as an object keyed by column name.
+	RowFormatObject = "object"
+	// RowFormatArray returns each row as an array in column order.
+	RowFormatArray = "array"
+)
+
+// columnInfo describes a result column of execute_query.
+type columnInfo struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Nullable  bool   `json:"nullable"`
+	Length    *int64 `json:"length,omitempty"`
+	Precision *int64 `json:"precision,omitempty"`
+	Scale     *int64 `json:"scale,omitempty"`
+	Schema    string `json:"schema,omitempty"`
+	Table     string `json:"table,omitempty"`
+	Column    string `json:"column,omitempty"`
+	Masked    string `json:"masked,omitempty"`
+}
+
+// columnSource is the table column a result column is read from.
+type columnSource struct {
+	Schema string
+	Table  string
+	Column string
+}
+
+// describeColumns builds the column metadata for a result set. sources may
+// be nil or contain nil entries when the origin of a column is unknown, and
+// masked holds the masking strategy of each column, if any.
+func describeColumns(columnTypes []*sql.ColumnType, sources []*columnSource, masked []string) []columnInfo {
+	columns := make([]columnInfo, len(columnTypes))
+	for i, ct := range columnTypes {
+		col := columnInfo{
+			Name: ct.Name(),
+			Type: ct.DatabaseTypeName(),
+		}
+		if nullable, ok := ct.Nullable(); ok {
+			col.Nullable = nullable
+		}
+		if length, ok := ct.Length(); ok {
+			col.Length = &length
+		}
+		if precision, scale, ok := ct.DecimalSize(); ok {
+			col.Precision = &precision
+			col.Scale = &scale
+		}
+		if i < len(sources) && sources[i] != nil {
+			col.Schema = sources[i].Schema
+			col.Table = sources[i].Table
+			col.Column = sources[i].Column
+		}
+		if i < len(masked) {
+			col.Masked = masked[i]
+		}
+		columns[i] = col
+	}
+	return columns
+}
+
+// resultColumnSources maps the named output columns of a SELECT to the base
+// table columns they are read from, using only the parse tree. Computed
+// columns, and columns whose table cannot be determined without looking at
+// the schema (unqualified names or * over a join), get a nil entry. It
+// returns nil for statements other than a plain SELECT.
+func resultColumnSources(stmt ast.StmtNode, defaultSchema string, names []string) []*columnSource {
+	sel, ok := stmt.(*ast.SelectStmt)
+	if !ok || sel.Kind != ast.SelectStmtKindSelect || sel.Fields == nil {
+		return nil
+	}
+
+	tables, sourceCount := fromTables(sel, defaultSchema)
+
+	// Resolve a table reference by alias or name; an empty reference is only
+	// unambiguous when there is a single table
+	resolve := func(schema, name string) *columnSource {
+		if name == "" {
+			if sourceCount == 1 && len(tables) == 1 {
+				for _, t := range tables {
+					return t
+				}
+			}
+			return nil
+		}
+		t, ok := tables[name]
+		if !ok || (schema != "" && !strings.EqualFold(t.Schema, schema)) {
+			return nil
+		}
+		return t
+	}
+
+	// A single * expands to all columns not produced by the other fields
+	wildcards := 0
+	for _, field := range sel.Fields.Fields {
+		if field.WildCard != nil {
+			wildcards++
+		}
+	}
+	if wildcards > 1 {
+		return nil
+	}
+	wildcardWidth := len(names) - (len(sel.Fields.Fields) - wildcards)
+
+	sources := make([]*columnSource, 0, len(names))
+	for _, field := range sel.Fields.Fields {
+		if field.WildCard != nil {
+			t := resolve(field.WildCard.Schema.L, field.WildCard.Table.L)
+			for i := 0; i < wildcardWidth; i++ {
+				if t == nil || len(sources) >= len(names) {
+					sources = append(sources, nil)
+				} else {
+					// Columns expanded from * keep their table column names
+					sources = append(sources, &columnSource{Schema: t.Schema, Table: t.Table, Column: names[len(sources)]})
+				}
+			}
+			continue
+		}
+
+		col, ok := field.Expr.(*ast.ColumnNameExpr)
+		if !ok {
+			sources = append(sources, nil)
+			continue
+		}
+		t := resolve(col.Name.Schema.L, col.Name.Table.L)
+		if t == nil {
+			sources = append(sources, nil)
+			continue
+		}
+		sources = append(sources, &columnSource{Schema: t.Schema, Table: t.Table, Column: col.Name.Name.O})
+	}
+
+	if len(sources) != len(names) {
+		return nil
+	}
+	return sources
+}
+
+// fromTables returns the base tables in the FROM clause of sel keyed by
+// the lower case name they are referenced by (alias or table name), and the
+// total number of table sources. Derived tables and CTEs are not returned.
+func fromTables(sel *ast.SelectStmt, defaultSchema string) (map[string]*columnSource, int) {
+	ctes := make(map[string]bool)
+	if sel.With != nil {
+		for _, cte := range sel.With.CTEs {
+			ctes[cte.Name.L] = true
+		}
+	}
+
+	tables := make(map[string]*columnSource)
+	count := 0
+	if sel.From == nil {
+		return tables, count
+	}
+
+	var walk func(node ast.ResultSetNode)
+	walk = func(node ast.ResultSetNode) {
+		switch n := node.(type) {
+		case *ast.Join:
+			if n.Left != nil {
+				walk(n.Left)
+			}
+			if n.Right != nil {
+				walk(n.Right)
+			}
+		case *ast.TableSource:
+			count++
+			name, ok := n.Source.(*ast.TableName)
+			if !ok {
+				return
+			}
+			if name.Schema.O == "" && ctes[name.Name.L] {
+				return
+			}
+			schema := name.Schema.O
+			if schema == "" {
+				schema = defaultSchema
+			}
+			key := name.Name.L
+			if n.AsName.L != "" {
+				key = n.AsName.L
+			}
+			tables[key] = &columnSource{Schema: schema, Table: name.Name.O}
+		}
+	}
+	walk(sel.From.TableRefs)
+
+	return tables, count
+}
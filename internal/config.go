@@ -0,0 +1,1014 @@
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration shared by all binaries. It is read from an
+// optional YAML or TOML file and overridden by MYSQL_* environment
+// variables.
+type Config struct {
+	// Profile names the connection profile used when none is selected
+	Profile  string                    `config:"profile"`
+	Profiles map[string]*ProfileConfig `config:"profiles"`
+
+	Limits  LimitsConfig  `config:"limits"`
+	Paging  PagingConfig  `config:"paging"`
+	Access  AccessConfig  `config:"access"`
+	Masking MaskingConfig `config:"masking"`
+	Output  OutputConfig  `config:"output"`
+	Audit   AuditConfig   `config:"audit"`
+	Auth    AuthConfig    `config:"auth"`
+	HTTP    HTTPConfig    `config:"http"`
+
+	Credentials CredentialsConfig `config:"credentials"`
+	RateLimits  RateLimitsConfig  `config:"rate_limits"`
+
+	// Connection is the selected profile
+	Connection *ProfileConfig `config:"-"`
+	// ConnectionName is the name of the selected profile, empty when no
+	// profiles are defined
+	ConnectionName string `config:"-"`
+}
+
+// ProfileConfig is a named MySQL connection.
+type ProfileConfig struct {
+	// DSN is a go-sql-driver DSN or mysql:// URL; the settings below
+	// override its parts
+	DSN string `config:"dsn"`
+
+	Host     string `config:"host"`
+	Port     int    `config:"port"`
+	User     string `config:"user"`
+	Password string `config:"password"`
+	Database string `config:"database"`
+	// Socket is a unix socket path used instead of host and port
+	Socket string `config:"socket"`
+
+	Charset              string   `config:"charset"`
+	Collation            string   `config:"collation"`
+	ParseTime            bool     `config:"parse_time"`
+	Loc                  string   `config:"loc"`
+	ConnectionAttributes []string `config:"connection_attributes"`
+
+	// Connection pool, zero keeps the database/sql default
+	MaxOpenConns    int           `config:"max_open_conns"`
+	MaxIdleConns    int           `config:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `config:"conn_max_lifetime"`
+
+	// TLS of the connection, see tlsconfig.go
+	TLSMode       string `config:"tls_mode"`
+	TLSCA         string `config:"tls_ca"`
+	TLSCert       string `config:"tls_cert"`
+	TLSKey        string `config:"tls_key"`
+	TLSServerName string `config:"tls_server_name"`
+}
+
+// LimitsConfig holds the safety limits of tool calls.
+type LimitsConfig struct {
+	ReadOnlyMode         string        `config:"read_only_mode"`
+	QueryTimeout         time.Duration `config:"query_timeout"`
+	MaxQueryCost         float64       `config:"max_query_cost"`
+	MaxRowsExamined      int64         `config:"max_rows_examined"`
+	FullScanRowThreshold int64         `config:"full_scan_row_threshold"`
+	MaxResponseBytes     int64         `config:"max_response_bytes"`
+	MaxCellBytes         int64         `config:"max_cell_bytes"`
+	CursorTTL            time.Duration `config:"cursor_ttl"`
+}
+
+// PagingConfig holds the page sizes of listings and query results.
+type PagingConfig struct {
+	DefaultPageSize   int `config:"default_page_size"`
+	MaxPageSize       int `config:"max_page_size"`
+	DefaultQueryLimit int `config:"default_query_limit"`
+	MaxQueryLimit     int `config:"max_query_limit"`
+}
+
+// AccessConfig holds the allow and deny name patterns.
+type AccessConfig struct {
+	AllowSchemas []string `config:"allow_schemas"`
+	DenySchemas  []string `config:"deny_schemas"`
+	AllowTables  []string `config:"allow_tables"`
+	DenyTables   []string `config:"deny_tables"`
+	AllowColumns []string `config:"allow_columns"`
+	DenyColumns  []string `config:"deny_columns"`
+}
+
+// MaskingConfig holds the data masking policy.
+type MaskingConfig struct {
+	Columns    []string `config:"columns"`
+	CommentTag string   `config:"comment_tag"`
+	Strategy   string   `config:"strategy"`
+	HashKey    string   `config:"hash_key"`
+}
+
+// OutputConfig holds the result format used when a tool call names none.
+type OutputConfig struct {
+	Format    string `config:"format"`
+	RowFormat string `config:"row_format"`
+}
+
+// AuditConfig holds the audit log settings.
+type AuditConfig struct {
+	Log        string   `config:"log"`
+	MaxSizeMB  int      `config:"max_size_mb"`
+	MaxBackups int      `config:"max_backups"`
+	MaxAgeDays int      `config:"max_age_days"`
+	RedactArgs []string `config:"redact_args"`
+}
+
+// AuthConfig holds the credentials accepted by the HTTP server.
+type AuthConfig struct {
+	// APIKeys maps key names, which identify the caller, to keys
+	APIKeys map[string]*APIKeyConfig `config:"api_keys"`
+	// OAuth accepts JWT access tokens issued by an authorization server
+	OAuth OAuthConfig `config:"oauth"`
+	// ClientCerts maps identity names to client certificate subjects,
+	// verified against http.client_ca
+	ClientCerts map[string]*ClientCertConfig `config:"client_certs"`
+}
+
+// APIKeyConfig is a static API key and its scopes.
+type APIKeyConfig struct {
+	// Hash is the hex SHA-256 of the key, optionally prefixed with sha256:
+	Hash string `config:"hash"`
+	// Tools, Schemas and Connections are name patterns the key is
+	// restricted to; empty allows all
+	Tools       []string `config:"tools"`
+	Schemas     []string `config:"schemas"`
+	Connections []string `config:"connections"`
+}
+
+// OAuthConfig makes the HTTP server an OAuth protected resource.
+type OAuthConfig struct {
+	// Resource is the URL of the MCP endpoint, published in the protected
+	// resource metadata
+	Resource             string   `config:"resource"`
+	AuthorizationServers []string `config:"authorization_servers"`
+	// Issuer and Audience are required of the iss and aud claims; the
+	// audience defaults to the resource
+	Issuer   string `config:"issuer"`
+	Audience string `config:"audience"`
+	// The signing keys, as a JWK set fetched from JWKSURL or read from
+	// JWKSFile
+	JWKSURL  string `config:"jwks_url"`
+	JWKSFile string `config:"jwks_file"`
+	// IdentityClaim names the caller, sub by default
+	IdentityClaim string `config:"identity_claim"`
+	// Scopes maps token scopes to what they grant. Without scopes every
+	// valid token has full access
+	Scopes map[string]*ScopeGrant `config:"scopes"`
+}
+
+// ClientCertConfig maps client certificates to an identity and its scopes.
+type ClientCertConfig struct {
+	// Subjects are patterns matched against the certificate subject, such
+	// as CN=reporting,O=Example, or its common name
+	Subjects    []string `config:"subjects"`
+	Tools       []string `config:"tools"`
+	Schemas     []string `config:"schemas"`
+	Connections []string `config:"connections"`
+}
+
+// ScopeGrant is what a token scope allows, as name patterns; empty allows
+// all.
+type ScopeGrant struct {
+	Tools       []string `config:"tools"`
+	Schemas     []string `config:"schemas"`
+	Connections []string `config:"connections"`
+}
+
+// HTTPConfig holds the HTTPS settings of the HTTP server.
+type HTTPConfig struct {
+	// TLSCert and TLSKey serve HTTPS, reloaded when the files change
+	TLSCert string `config:"tls_cert"`
+	TLSKey  string `config:"tls_key"`
+	// ClientCA verifies client certificates
+	ClientCA string `config:"client_ca"`
+	// ClientAuth is require, or optional when other credentials may be
+	// used instead of a certificate
+	ClientAuth string `config:"client_auth"`
+}
+
+// CredentialsConfig selects the MySQL user of HTTP tool calls.
+type CredentialsConfig struct {
+	// Mode is shared, identity or header, see credentials.go
+	Mode string `config:"mode"`
+	// Users maps identity names to MySQL users in identity mode
+	Users map[string]*DBUserConfig `config:"users"`
+	// Per-user connection pools: at most MaxPools, each closed when unused
+	// for IdleTimeout and holding up to MaxOpenConns connections
+	MaxPools     int           `config:"max_pools"`
+	IdleTimeout  time.Duration `config:"idle_timeout"`
+	MaxOpenConns int           `config:"max_open_conns"`
+}
+
+// RateLimitsConfig holds the limits on tool calls, across all callers and
+// per identity, and per tool on top of them.
+type RateLimitsConfig struct {
+	// Window is the period over which rows and bytes are counted
+	Window      time.Duration              `config:"window"`
+	Global      RateLimitConfig            `config:"global"`
+	PerIdentity RateLimitConfig            `config:"per_identity"`
+	Tools       map[string]*ToolRateLimits `config:"tools"`
+}
+
+// ToolRateLimits holds the limits on the calls of one tool.
+type ToolRateLimits struct {
+	Global      RateLimitConfig `config:"global"`
+	PerIdentity RateLimitConfig `config:"per_identity"`
+}
+
+// RateLimitConfig holds the limits of one scope; zero disables a limit.
+type RateLimitConfig struct {
+	CallsPerMinute int   `config:"calls_per_minute"`
+	Concurrent     int   `config:"concurrent"`
+	Rows           int64 `config:"rows"`
+	Bytes          int64 `config:"bytes"`
+}
+
+// DBUserConfig is a MySQL account.
+type DBUserConfig struct {
+	User     string `config:"user"`
+	Password string `config:"password"`
+}
+
+// enabled reports whether OAuth tokens are accepted.
+func (c *OAuthConfig) enabled() bool {
+	return c.Resource != "" || c.JWKSURL != "" || c.JWKSFile != "" || c.Issuer != ""
+}
+
+// defaultConfig returns the configuration used when nothing is set.
+func defaultConfig() *Config {
+	return &Config{
+		Limits: LimitsConfig{
+			ReadOnlyMode:     ReadOnlyTransaction,
+			QueryTimeout:     DefaultQueryTimeout,
+			MaxResponseBytes: DefaultMaxResponseBytes,
+			MaxCellBytes:     DefaultMaxCellBytes,
+			CursorTTL:        DefaultCursorTTL,
+		},
+		Paging: PagingConfig{
+			DefaultPageSize:   DefaultPageSize,
+			MaxPageSize:       MaxPageSize,
+			DefaultQueryLimit: DefaultQueryLimit,
+			MaxQueryLimit:     MaxQueryLimit,
+		},
+		Masking: MaskingConfig{Strategy: MaskRedact},
+		Output:  OutputConfig{Format: FormatJSON, RowFormat: RowFormatObject},
+		Audit:   AuditConfig{MaxSizeMB: DefaultAuditMaxSizeMB, MaxBackups: DefaultAuditMaxBackups},
+		Credentials: CredentialsConfig{
+			Mode:         CredentialsShared,
+			MaxPools:     DefaultMaxUserPools,
+			IdleTimeout:  DefaultUserPoolIdleTimeout,
+			MaxOpenConns: DefaultUserPoolMaxOpenConns,
+		},
+		RateLimits: RateLimitsConfig{Window: DefaultRateLimitWindow},
+	}
+}
+
+// profileEnv maps environment variables to keys of the selected profile.
+var profileEnv = []struct{ env, key string }{
+	{"MYSQL_DSN", "dsn"},
+	{"MYSQL_HOST", "host"},
+	{"MYSQL_PORT", "port"},
+	{"MYSQL_USER", "user"},
+	{"MYSQL_PASSWORD", "password"},
+	{"MYSQL_DATABASE", "database"},
+	{"MYSQL_SOCKET", "socket"},
+	{"MYSQL_CHARSET", "charset"},
+	{"MYSQL_COLLATION", "collation"},
+	{"MYSQL_PARSE_TIME", "parse_time"},
+	{"MYSQL_LOC", "loc"},
+	{"MYSQL_CONNECTION_ATTRIBUTES", "connection_attributes"},
+	{"MYSQL_MAX_OPEN_CONNS", "max_open_conns"},
+	{"MYSQL_MAX_IDLE_CONNS", "max_idle_conns"},
+	{"MYSQL_CONN_MAX_LIFETIME", "conn_max_lifetime"},
+	{"MYSQL_TLS_MODE", "tls_mode"},
+	{"MYSQL_TLS_CA", "tls_ca"},
+	{"MYSQL_TLS_CERT", "tls_cert"},
+	{"MYSQL_TLS_KEY", "tls_key"},
+	{"MYSQL_TLS_SERVER_NAME", "tls_server_name"},
+}
+
+// settingEnv maps environment variables to configuration keys.
+var settingEnv = []struct{ env, key string }{
+	{"MYSQL_READ_ONLY_MODE", "limits.read_only_mode"},
+	{"MYSQL_QUERY_TIMEOUT", "limits.query_timeout"},
+	{"MYSQL_MAX_QUERY_COST", "limits.max_query_cost"},
+	{"MYSQL_MAX_ROWS_EXAMINED", "limits.max_rows_examined"},
+	{"MYSQL_FULL_SCAN_ROW_THRESHOLD", "limits.full_scan_row_threshold"},
+	{"MYSQL_MAX_RESPONSE_BYTES", "limits.max_response_bytes"},
+	{"MYSQL_MAX_CELL_BYTES", "limits.max_cell_bytes"},
+	{"MYSQL_CURSOR_TTL", "limits.cursor_ttl"},
+	{"MYSQL_DEFAULT_PAGE_SIZE", "paging.default_page_size"},
+	{"MYSQL_MAX_PAGE_SIZE", "paging.max_page_size"},
+	{"MYSQL_DEFAULT_QUERY_LIMIT", "paging.default_query_limit"},
+	{"MYSQL_MAX_QUERY_LIMIT", "paging.max_query_limit"},
+	{"MYSQL_ALLOW_SCHEMAS", "access.allow_schemas"},
+	{"MYSQL_DENY_SCHEMAS", "access.deny_schemas"},
+	{"MYSQL_ALLOW_TABLES", "access.allow_tables"},
+	{"MYSQL_DENY_TABLES", "access.deny_tables"},
+	{"MYSQL_ALLOW_COLUMNS", "access.allow_columns"},
+	{"MYSQL_DENY_COLUMNS", "access.deny_columns"},
+	{"MYSQL_MASK_COLUMNS", "masking.columns"},
+	{"MYSQL_MASK_COMMENT_TAG", "masking.comment_tag"},
+	{"MYSQL_MASK_STRATEGY", "masking.strategy"},
+	{"MYSQL_MASK_HASH_KEY", "masking.hash_key"},
+	{"MYSQL_DEFAULT_FORMAT", "output.format"},
+	{"MYSQL_DEFAULT_ROW_FORMAT", "output.row_format"},
+	{"MYSQL_AUDIT_LOG", "audit.log"},
+	{"MYSQL_AUDIT_MAX_SIZE_MB", "audit.max_size_mb"},
+	{"MYSQL_AUDIT_MAX_BACKUPS", "audit.max_backups"},
+	{"MYSQL_AUDIT_MAX_AGE_DAYS", "audit.max_age_days"},
+	{"MYSQL_AUDIT_REDACT_ARGS", "audit.redact_args"},
+	{"MYSQL_OAUTH_RESOURCE", "auth.oauth.resource"},
+	{"MYSQL_OAUTH_AUTHORIZATION_SERVERS", "auth.oauth.authorization_servers"},
+	{"MYSQL_OAUTH_ISSUER", "auth.oauth.issuer"},
+	{"MYSQL_OAUTH_AUDIENCE", "auth.oauth.audience"},
+	{"MYSQL_OAUTH_JWKS_URL", "auth.oauth.jwks_url"},
+	{"MYSQL_OAUTH_JWKS_FILE", "auth.oauth.jwks_file"},
+	{"MYSQL_HTTP_TLS_CERT", "http.tls_cert"},
+	{"MYSQL_HTTP_TLS_KEY", "http.tls_key"},
+	{"MYSQL_HTTP_CLIENT_CA", "http.client_ca"},
+	{"MYSQL_HTTP_CLIENT_AUTH", "http.client_auth"},
+	{"MYSQL_CREDENTIALS_MODE", "credentials.mode"},
+	{"MYSQL_CREDENTIALS_MAX_POOLS", "credentials.max_pools"},
+	{"MYSQL_CREDENTIALS_IDLE_TIMEOUT", "credentials.idle_timeout"},
+	{"MYSQL_CREDENTIALS_MAX_OPEN_CONNS", "credentials.max_open_conns"},
+	{"MYSQL_RATE_LIMIT_WINDOW", "rate_limits.window"},
+	{"MYSQL_RATE_LIMIT_CALLS_PER_MINUTE", "rate_limits.global.calls_per_minute"},
+	{"MYSQL_RATE_LIMIT_CONCURRENT", "rate_limits.global.concurrent"},
+	{"MYSQL_RATE_LIMIT_ROWS", "rate_limits.global.rows"},
+	{"MYSQL_RATE_LIMIT_BYTES", "rate_limits.global.bytes"},
+	{"MYSQL_IDENTITY_RATE_LIMIT_CALLS_PER_MINUTE", "rate_limits.per_identity.calls_per_minute"},
+	{"MYSQL_IDENTITY_RATE_LIMIT_CONCURRENT", "rate_limits.per_identity.concurrent"},
+	{"MYSQL_IDENTITY_RATE_LIMIT_ROWS", "rate_limits.per_identity.rows"},
+	{"MYSQL_IDENTITY_RATE_LIMIT_BYTES", "rate_limits.per_identity.bytes"},
+}
+
+// configKey names a key in errors, with the environment variable that
+// overrides it.
+func configKey(key string) string {
+	for _, s := range settingEnv {
+		if s.key == key {
+			return fmt.Sprintf("%s (%s)", key, s.env)
+		}
+	}
+	return key
+}
+
+// LoadConfig reads the configuration file at path, or at MYSQL_MCP_CONFIG
+// when path is empty, applies environment overrides and validates the
+// result. Without a file the configuration comes from the environment
+// alone. profile selects the connection profile and defaults to
+// MYSQL_PROFILE, then to the file's profile key, then to the only profile.
+func LoadConfig(path, profile string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv("MYSQL_MCP_CONFIG")
+	}
+
+	cfg := defaultConfig()
+	if path != "" {
+		tree, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeConfigValue("", tree, reflect.ValueOf(cfg).Elem()); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.selectProfile(profile); err != nil {
+		return nil, err
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// readConfigFile parses a YAML or TOML file, chosen by its extension, into
+// a tree of maps.
+func readConfigFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	tree := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var doc yaml.Node
+		if err = yaml.Unmarshal(data, &doc); err == nil {
+			quoteNumbers(&doc)
+			err = doc.Decode(&tree)
+		}
+	case ".toml":
+		_, err = toml.Decode(string(data), &tree)
+	default:
+		return nil, fmt.Errorf("unsupported config file %s (expected .yaml, .yml or .toml)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return tree, nil
+}
+
+// quoteNumbers turns the numbers of a YAML document into strings with
+// their original text, so that an unquoted password such as 0123 is not
+// read as the octal number 83. Numeric settings parse them like values
+// from the environment.
+func quoteNumbers(n *yaml.Node) {
+	if n.Kind == yaml.ScalarNode && (n.ShortTag() == "!!int" || n.ShortTag() == "!!float") {
+		n.Tag = "!!str"
+	}
+	for _, child := range n.Content {
+		quoteNumbers(child)
+	}
+}
+
+// selectProfile picks the connection profile and fills in its defaults.
+func (c *Config) selectProfile(name string) error {
+	if name == "" {
+		name = os.Getenv("MYSQL_PROFILE")
+	}
+	if name == "" {
+		name = c.Profile
+	}
+	if name == "" && len(c.Profiles) == 1 {
+		for only := range c.Profiles {
+			name = only
+		}
+	}
+
+	switch {
+	case name != "":
+		profile, ok := c.Profiles[name]
+		if !ok || profile == nil {
+			return fmt.Errorf("unknown profile %q (defined: %s)", name, strings.Join(c.profileNames(), ", "))
+		}
+		c.Connection = profile
+		c.ConnectionName = name
+	case len(c.Profiles) > 1:
+		return fmt.Errorf("several profiles are defined (%s); select one with -profile, MYSQL_PROFILE or the profile key", strings.Join(c.profileNames(), ", "))
+	default:
+		c.Connection = &ProfileConfig{}
+	}
+	return nil
+}
+
+func (c *Config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyEnv overrides the configuration with the MYSQL_* environment
+// variables that are set. Profile variables apply to the selected profile.
+func (c *Config) applyEnv() error {
+	for _, s := range profileEnv {
+		if v, ok := os.LookupEnv(s.env); ok && v != "" {
+			if err := setConfigKey(reflect.ValueOf(c.Connection).Elem(), s.key, v); err != nil {
+				return fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+	for _, s := range settingEnv {
+		if v, ok := os.LookupEnv(s.env); ok && v != "" {
+			if err := setConfigKey(reflect.ValueOf(c).Elem(), s.key, v); err != nil {
+				return fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, p := range append(c.profileList(), c.Connection) {
+		if p.DSN != "" {
+			// The DSN has its own defaults
+			continue
+		}
+		if p.Host == "" {
+			p.Host = "localhost"
+		}
+		if p.Port == 0 {
+			p.Port = 3306
+		}
+		if p.User == "" {
+			p.User = "root"
+		}
+	}
+	return nil
+}
+
+// profileList returns the defined profiles in name order.
+func (c *Config) profileList() []*ProfileConfig {
+	var profiles []*ProfileConfig
+	for _, name := range c.profileNames() {
+		profiles = append(profiles, c.Profiles[name])
+	}
+	return profiles
+}
+
+// validate checks values that parse but make no sense.
+func (c *Config) validate() error {
+	if len(c.Profiles) == 0 {
+		if err := validateProfile("", c.Connection); err != nil {
+			return err
+		}
+	}
+	for _, name := range c.profileNames() {
+		if err := validateProfile("profiles."+name+".", c.Profiles[name]); err != nil {
+			return err
+		}
+	}
+
+	l := c.Limits
+	switch l.ReadOnlyMode {
+	case ReadOnlyTransaction, ReadOnlySession, ReadOnlyOff:
+	default:
+		return fmt.Errorf("%s: invalid value %q (expected %s, %s or %s)", configKey("limits.read_only_mode"),
+			l.ReadOnlyMode, ReadOnlyTransaction, ReadOnlySession, ReadOnlyOff)
+	}
+	for key, d := range map[string]time.Duration{"limits.query_timeout": l.QueryTimeout, "limits.cursor_ttl": l.CursorTTL} {
+		if d < 0 {
+			return fmt.Errorf("%s: must not be negative", configKey(key))
+		}
+	}
+	if l.CursorTTL == 0 {
+		return fmt.Errorf("%s: must be positive", configKey("limits.cursor_ttl"))
+	}
+	if l.MaxQueryCost < 0 {
+		return fmt.Errorf("%s: must not be negative", configKey("limits.max_query_cost"))
+	}
+	for key, n := range map[string]int64{
+		"limits.max_rows_examined":       l.MaxRowsExamined,
+		"limits.full_scan_row_threshold": l.FullScanRowThreshold,
+		"limits.max_response_bytes":      l.MaxResponseBytes,
+		"limits.max_cell_bytes":          l.MaxCellBytes,
+		"audit.max_size_mb":              int64(c.Audit.MaxSizeMB),
+		"audit.max_backups":              int64(c.Audit.MaxBackups),
+		"audit.max_age_days":             int64(c.Audit.MaxAgeDays),
+	} {
+		if n < 0 {
+			return fmt.Errorf("%s: must not be negative", configKey(key))
+		}
+	}
+
+	pg := c.Paging
+	for _, size := range []struct {
+		key          string
+		value, limit int
+	}{
+		{"paging.max_page_size", pg.MaxPageSize, 0},
+		{"paging.default_page_size", pg.DefaultPageSize, pg.MaxPageSize},
+		{"paging.max_query_limit", pg.MaxQueryLimit, 0},
+		{"paging.default_query_limit", pg.DefaultQueryLimit, pg.MaxQueryLimit},
+	} {
+		if size.value <= 0 {
+			return fmt.Errorf("%s: must be positive", configKey(size.key))
+		}
+		if size.limit > 0 && size.value > size.limit {
+			return fmt.Errorf("%s: %d is larger than the maximum %d", configKey(size.key), size.value, size.limit)
+		}
+	}
+
+	keyNames := make([]string, 0, len(c.Auth.APIKeys))
+	for name := range c.Auth.APIKeys {
+		keyNames = append(keyNames, name)
+	}
+	sort.Strings(keyNames)
+	for _, name := range keyNames {
+		key := c.Auth.APIKeys[name]
+		if _, err := parseKeyHash(key.Hash); err != nil {
+			return fmt.Errorf("auth.api_keys.%s.hash: %w", name, err)
+		}
+		if _, err := parseNamePatterns(key.Schemas, 1); err != nil {
+			return fmt.Errorf("auth.api_keys.%s.schemas: %w", name, err)
+		}
+	}
+	if err := c.Auth.OAuth.validate(); err != nil {
+		return err
+	}
+
+	certNames := make([]string, 0, len(c.Auth.ClientCerts))
+	for name := range c.Auth.ClientCerts {
+		certNames = append(certNames, name)
+	}
+	sort.Strings(certNames)
+	for _, name := range certNames {
+		cert := c.Auth.ClientCerts[name]
+		if len(cert.Subjects) == 0 {
+			return fmt.Errorf("auth.client_certs.%s.subjects: must not be empty", name)
+		}
+		if _, err := parseNamePatterns(cert.Schemas, 1); err != nil {
+			return fmt.Errorf("auth.client_certs.%s.schemas: %w", name, err)
+		}
+	}
+	switch c.HTTP.ClientAuth {
+	case "", ClientAuthRequire, ClientAuthOptional:
+	default:
+		return fmt.Errorf("%s: invalid value %q (expected %s or %s)", configKey("http.client_auth"), c.HTTP.ClientAuth, ClientAuthRequire, ClientAuthOptional)
+	}
+
+	if err := c.Credentials.validate(); err != nil {
+		return err
+	}
+	if err := c.RateLimits.validate(); err != nil {
+		return err
+	}
+
+	if _, err := parseFormat(c.Output.Format, FormatJSON); err != nil {
+		return fmt.Errorf("%s: %w", configKey("output.format"), err)
+	}
+	if _, err := parseRowFormat(c.Output.RowFormat, RowFormatObject); err != nil {
+		return fmt.Errorf("%s: %w", configKey("output.row_format"), err)
+	}
+	return nil
+}
+
+// validateProfile checks the connection settings of p, whose keys start
+// with prefix.
+func validateProfile(prefix string, p *ProfileConfig) error {
+	key := func(name string) string {
+		return profileKey(prefix, name)
+	}
+	if p.DSN != "" {
+		if _, err := parseDSN(p.DSN); err != nil {
+			return fmt.Errorf("%s: %w", key("dsn"), err)
+		}
+	}
+	if (p.Port != 0 || p.DSN == "") && (p.Port < 1 || p.Port > 65535) {
+		return fmt.Errorf("%s: %d is not a valid port", key("port"), p.Port)
+	}
+	if p.Loc != "" {
+		if _, err := time.LoadLocation(p.Loc); err != nil {
+			return fmt.Errorf("%s: unknown time zone %q", key("loc"), p.Loc)
+		}
+	}
+	for _, attr := range p.ConnectionAttributes {
+		if k, _, ok := strings.Cut(attr, ":"); !ok || k == "" {
+			return fmt.Errorf("%s: expected key:value, got %q", key("connection_attributes"), attr)
+		}
+	}
+	for name, n := range map[string]int64{
+		"max_open_conns":    int64(p.MaxOpenConns),
+		"max_idle_conns":    int64(p.MaxIdleConns),
+		"conn_max_lifetime": int64(p.ConnMaxLifetime),
+	} {
+		if n < 0 {
+			return fmt.Errorf("%s: must not be negative", key(name))
+		}
+	}
+	if p.MaxOpenConns == 1 {
+		// A timed out statement is killed from a second connection
+		return fmt.Errorf("%s: must be at least 2", key("max_open_conns"))
+	}
+
+	switch p.TLSMode {
+	case "", TLSDisabled, TLSPreferred, TLSRequired, TLSSkipVerify:
+	default:
+		return fmt.Errorf("%s: invalid value %q (expected %s)", key("tls_mode"), p.TLSMode, strings.Join(tlsModes, ", "))
+	}
+	if (p.TLSCert == "") != (p.TLSKey == "") {
+		return fmt.Errorf("%s and %s must be set together", key("tls_cert"), key("tls_key"))
+	}
+	if p.TLSMode == TLSDisabled && (p.TLSCA != "" || p.TLSCert != "" || p.TLSServerName != "") {
+		return fmt.Errorf("%s: TLS files or a server name are set but TLS is disabled", key("tls_mode"))
+	}
+	return nil
+}
+
+// validate checks that the rate limits are not negative.
+func (c *RateLimitsConfig) validate() error {
+	if c.Window <= 0 {
+		return fmt.Errorf("%s: must be positive", configKey("rate_limits.window"))
+	}
+	scopes := map[string]RateLimitConfig{
+		"rate_limits.global":       c.Global,
+		"rate_limits.per_identity": c.PerIdentity,
+	}
+	for tool, limits := range c.Tools {
+		scopes["rate_limits.tools."+tool+".global"] = limits.Global
+		scopes["rate_limits.tools."+tool+".per_identity"] = limits.PerIdentity
+	}
+	keys := make([]string, 0, len(scopes))
+	for key := range scopes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		l := scopes[key]
+		for name, n := range map[string]int64{
+			"calls_per_minute": int64(l.CallsPerMinute),
+			"concurrent":       int64(l.Concurrent),
+			"rows":             l.Rows,
+			"bytes":            l.Bytes,
+		} {
+			if n < 0 {
+				return fmt.Errorf("%s: must not be negative", configKey(key+"."+name))
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the credentials mode and the limits of per-user pools.
+func (c *CredentialsConfig) validate() error {
+	switch c.Mode {
+	case CredentialsShared, CredentialsIdentity, CredentialsHeader:
+	default:
+		return fmt.Errorf("%s: invalid value %q (expected %s, %s or %s)", configKey("credentials.mode"),
+			c.Mode, CredentialsShared, CredentialsIdentity, CredentialsHeader)
+	}
+	if c.Mode == CredentialsIdentity && len(c.Users) == 0 {
+		return fmt.Errorf("credentials.users: must map identities to MySQL users in %s mode", CredentialsIdentity)
+	}
+	users := make([]string, 0, len(c.Users))
+	for name := range c.Users {
+		users = append(users, name)
+	}
+	sort.Strings(users)
+	for _, name := range users {
+		if c.Users[name].User == "" {
+			return fmt.Errorf("credentials.users.%s.user: must be set", name)
+		}
+	}
+
+	if c.MaxPools <= 0 {
+		return fmt.Errorf("%s: must be positive", configKey("credentials.max_pools"))
+	}
+	if c.IdleTimeout <= 0 {
+		return fmt.Errorf("%s: must be positive", configKey("credentials.idle_timeout"))
+	}
+	if c.MaxOpenConns < 2 {
+		// A timed out statement is killed from a second connection
+		return fmt.Errorf("%s: must be at least 2", configKey("credentials.max_open_conns"))
+	}
+	return nil
+}
+
+// validate checks the OAuth settings when OAuth is enabled.
+func (c *OAuthConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	u, err := url.Parse(c.Resource)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.Fragment != "" {
+		return fmt.Errorf("%s: expected the http(s) URL of the MCP endpoint, got %q", configKey("auth.oauth.resource"), c.Resource)
+	}
+	if (c.JWKSURL == "") == (c.JWKSFile == "") {
+		return fmt.Errorf("exactly one of %s and %s must be set", configKey("auth.oauth.jwks_url"), configKey("auth.oauth.jwks_file"))
+	}
+	if len(c.AuthorizationServers) == 0 && c.Issuer == "" {
+		return fmt.Errorf("%s or %s must be set", configKey("auth.oauth.authorization_servers"), configKey("auth.oauth.issuer"))
+	}
+
+	scopes := make([]string, 0, len(c.Scopes))
+	for scope := range c.Scopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	for _, scope := range scopes {
+		if _, err := parseNamePatterns(c.Scopes[scope].Schemas, 1); err != nil {
+			return fmt.Errorf("auth.oauth.scopes.%s.schemas: %w", scope, err)
+		}
+	}
+	return nil
+}
+
+// profileKey names a key of a profile in errors. Without profiles the
+// settings come from the environment, which is named instead.
+func profileKey(prefix, name string) string {
+	if prefix == "" {
+		for _, s := range profileEnv {
+			if s.key == name {
+				return fmt.Sprintf("%s (%s)", name, s.env)
+			}
+		}
+	}
+	return prefix + name
+}
+
+// setConfigKey sets the dotted key below v from a string.
+func setConfigKey(v reflect.Value, key, value string) error {
+	for _, name := range strings.Split(key, ".") {
+		field, ok := configField(v, name)
+		if !ok {
+			return fmt.Errorf("unknown key %s", key)
+		}
+		v = field
+	}
+	return decodeConfigValue("", value, v)
+}
+
+// configField returns the struct field of v tagged name.
+func configField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("config"); tag == name && tag != "-" {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decodeConfigValue stores a parsed YAML or TOML value, or a string from the
+// environment, in v. Errors name the offending key. Unknown keys are
+// rejected so that typos do not go unnoticed.
+func decodeConfigValue(key string, src interface{}, v reflect.Value) error {
+	fail := func(format string, args ...interface{}) error {
+		msg := fmt.Sprintf(format, args...)
+		if key == "" {
+			return fmt.Errorf("%s", msg)
+		}
+		return fmt.Errorf("%s: %s", configKey(key), msg)
+	}
+	join := func(name string) string {
+		if key == "" {
+			return name
+		}
+		return key + "." + name
+	}
+
+	if src == nil {
+		return nil
+	}
+
+	switch {
+	case v.Type() == durationType:
+		s, ok := src.(string)
+		if !ok {
+			return fail("expected a duration such as 30s, got %v", src)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fail("invalid duration %q (expected e.g. 30s or 10m)", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+
+	case v.Kind() == reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeConfigValue(key, src, v.Elem())
+
+	case v.Kind() == reflect.Struct:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return fail("expected a table of settings")
+		}
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			field, ok := configField(v, name)
+			if !ok {
+				return fmt.Errorf("%s: unknown key", join(name))
+			}
+			if err := decodeConfigValue(join(name), m[name], field); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case v.Kind() == reflect.Map:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return fail("expected a table")
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for name, item := range m {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeConfigValue(join(name), item, elem); err != nil {
+				return err
+			}
+			if elem.Kind() == reflect.Ptr && elem.IsNil() {
+				// An entry without settings
+				elem.Set(reflect.New(elem.Type().Elem()))
+			}
+			v.SetMapIndex(reflect.ValueOf(name), elem)
+		}
+		return nil
+
+	case v.Kind() == reflect.Slice:
+		var items []string
+		switch val := src.(type) {
+		case string:
+			// Comma separated, as in environment variables
+			for _, item := range strings.Split(val, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+		case []interface{}:
+			for i, item := range val {
+				s, ok := item.(string)
+				if !ok {
+					return fail("item %d: expected a string, got %v", i+1, item)
+				}
+				items = append(items, s)
+			}
+		default:
+			return fail("expected a list of strings")
+		}
+		v.Set(reflect.ValueOf(items))
+		return nil
+
+	case v.Kind() == reflect.String:
+		switch val := src.(type) {
+		case string:
+			v.SetString(val)
+		case int64, float64:
+			// A TOML number may not be written the way it was meant
+			return fail("expected a string, got the number %v (quote it)", val)
+		default:
+			return fail("expected a string, got %v", src)
+		}
+		return nil
+
+	case v.Kind() == reflect.Bool:
+		switch val := src.(type) {
+		case bool:
+			v.SetBool(val)
+		case string:
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return fail("expected true or false, got %q", val)
+			}
+			v.SetBool(b)
+		default:
+			return fail("expected true or false, got %v", src)
+		}
+		return nil
+
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		var n int64
+		switch val := src.(type) {
+		case int:
+			n = int64(val)
+		case int64:
+			n = val
+		case float64:
+			if val != float64(int64(val)) {
+				return fail("expected an integer, got %v", val)
+			}
+			n = int64(val)
+		case string:
+			parsed, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+			if err != nil {
+				return fail("expected an integer, got %q", val)
+			}
+			n = parsed
+		default:
+			return fail("expected an integer, got %v", src)
+		}
+		v.SetInt(n)
+		return nil
+
+	case v.Kind() == reflect.Float64:
+		var f float64
+		switch val := src.(type) {
+		case int:
+			f = float64(val)
+		case int64:
+			f = float64(val)
+		case float64:
+			f = val
+		case string:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil {
+				return fail("expected a number, got %q", val)
+			}
+			f = parsed
+		default:
+			return fail("expected a number, got %v", src)
+		}
+		v.SetFloat(f)
+		return nil
+	}
+	return fail("unsupported setting")
+}
@@ -0,0 +1,190 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+)
+
+// queryPlan is a summary of EXPLAIN FORMAT=JSON output.
+type queryPlan struct {
+	QueryCost     float64     `json:"query_cost"`
+	RowsExamined  float64     `json:"estimated_rows_examined"`
+	Tables        []planTable `json:"tables"`
+	FullScanCount int         `json:"full_scans"`
+}
+
+// planTable describes how a single table is accessed by a query plan.
+type planTable struct {
+	Table        string   `json:"table"`
+	AccessType   string   `json:"access_type"`
+	Key          string   `json:"key,omitempty"`
+	PossibleKeys []string `json:"possible_keys,omitempty"`
+	RowsPerScan  float64  `json:"rows_examined_per_scan"`
+	Loops        float64  `json:"loops"`
+	FullScan     bool     `json:"full_scan"`
+}
+
+// costRejectedError reports a statement refused by the cost guard.
+type costRejectedError struct {
+	reasons []string
+	plan    *queryPlan
+}
+
+func (e *costRejectedError) Error() string {
+	return "query rejected: estimated cost exceeds the server's limits"
+}
+
+// result returns the rejection as a tool error the agent can act on.
+func (e *costRejectedError) result() (*mcp.CallToolResult, error) {
+	return jsonErrorResult(map[string]interface{}{
+		"error":   e.Error(),
+		"reasons": e.reasons,
+		"plan":    e.plan,
+		"hint":    "Add selective WHERE conditions on indexed columns or use a better index, or pass force=true to run it anyway",
+	})
+}
+
+// costGuardEnabled reports whether any cost threshold is configured.
+func (ms *MySQLServer) costGuardEnabled() bool {
+	return ms.maxQueryCost > 0 || ms.maxRowsExamined > 0 || ms.fullScanRowThreshold > 0
+}
+
+// isPlannable reports whether EXPLAIN can estimate the cost of stmt.
+func isPlannable(stmt ast.StmtNode) bool {
+	switch stmt.(type) {
+	case *ast.SelectStmt, *ast.SetOprStmt:
+		return true
+	}
+	return false
+}
+
+// explainQuery runs EXPLAIN FORMAT=JSON for query and summarizes the plan.
+func explainQuery(ctx context.Context, q queryer, query string, args ...any) (*queryPlan, error) {
+	var planJSON string
+	if err := q.QueryRowContext(ctx, "EXPLAIN FORMAT=JSON "+query, args...).Scan(&planJSON); err != nil {
+		return nil, fmt.Errorf("failed to explain query: %w", err)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(planJSON), &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse query plan: %w", err)
+	}
+
+	plan := &queryPlan{}
+	if block, ok := doc["query_block"].(map[string]any); ok {
+		if costInfo, ok := block["cost_info"].(map[string]any); ok {
+			plan.QueryCost = planNumber(costInfo["query_cost"])
+		}
+	}
+	collectPlanTables(doc, 1, plan)
+
+	for _, t := range plan.Tables {
+		plan.RowsExamined += t.RowsPerScan * t.Loops
+		if t.FullScan {
+			plan.FullScanCount++
+		}
+	}
+
+	return plan, nil
+}
+
+// collectPlanTables walks an EXPLAIN JSON document and records every table
+// access. Tables joined in a nested loop are scanned once per row produced
+// by the tables before them.
+func collectPlanTables(node any, loops float64, plan *queryPlan) {
+	switch n := node.(type) {
+	case map[string]any:
+		for key, value := range n {
+			switch key {
+			case "table":
+				if table, ok := value.(map[string]any); ok {
+					plan.Tables = append(plan.Tables, newPlanTable(table, loops))
+					collectPlanTables(table, loops, plan)
+				}
+			case "nested_loop":
+				steps, _ := value.([]any)
+				stepLoops := loops
+				for _, step := range steps {
+					collectPlanTables(step, stepLoops, plan)
+					if s, ok := step.(map[string]any); ok {
+						if table, ok := s["table"].(map[string]any); ok {
+							if produced := planNumber(table["rows_produced_per_join"]); produced > 0 {
+								stepLoops = produced
+							}
+						}
+					}
+				}
+			default:
+				collectPlanTables(value, loops, plan)
+			}
+		}
+	case []any:
+		for _, value := range n {
+			collectPlanTables(value, loops, plan)
+		}
+	}
+}
+
+func newPlanTable(table map[string]any, loops float64) planTable {
+	t := planTable{Loops: loops}
+	t.Table, _ = table["table_name"].(string)
+	t.AccessType, _ = table["access_type"].(string)
+	t.Key, _ = table["key"].(string)
+	if keys, ok := table["possible_keys"].([]any); ok {
+		for _, k := range keys {
+			if s, ok := k.(string); ok {
+				t.PossibleKeys = append(t.PossibleKeys, s)
+			}
+		}
+	}
+	t.RowsPerScan = planNumber(table["rows_examined_per_scan"])
+	// ALL is a full table scan, index a full index scan
+	t.FullScan = t.AccessType == "ALL" || t.AccessType == "index"
+	return t
+}
+
+// planNumber converts an EXPLAIN JSON value, which MySQL emits either as a
+// number or as a quoted decimal, to a float.
+func planNumber(v any) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	}
+	return 0
+}
+
+// checkQueryCost returns the reasons why plan exceeds the configured limits.
+func (ms *MySQLServer) checkQueryCost(plan *queryPlan) []string {
+	var reasons []string
+
+	if ms.maxQueryCost > 0 && plan.QueryCost > ms.maxQueryCost {
+		reasons = append(reasons, fmt.Sprintf("estimated query cost %.0f exceeds the limit of %.0f", plan.QueryCost, ms.maxQueryCost))
+	}
+	if ms.maxRowsExamined > 0 && plan.RowsExamined > float64(ms.maxRowsExamined) {
+		reasons = append(reasons, fmt.Sprintf("estimated %.0f rows examined exceeds the limit of %d", plan.RowsExamined, ms.maxRowsExamined))
+	}
+	if ms.fullScanRowThreshold > 0 {
+		for _, t := range plan.Tables {
+			if t.FullScan && t.RowsPerScan >= float64(ms.fullScanRowThreshold) {
+				reasons = append(reasons, fmt.Sprintf("full %s scan of table %s (~%.0f rows)", fullScanKind(t), t.Table, t.RowsPerScan))
+			}
+		}
+	}
+
+	return reasons
+}
+
+func fullScanKind(t planTable) string {
+	if t.AccessType == "index" {
+		return "index"
+	}
+	return "table"
+}
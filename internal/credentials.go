@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Credentials modes, selecting the MySQL user of HTTP tool calls
+const (
+	// CredentialsShared runs every tool call as the user of the connection
+	// profile.
+	CredentialsShared = "shared"
+	// CredentialsIdentity runs the tool calls of an authenticated caller as
+	// the MySQL user mapped to its identity.
+	CredentialsIdentity = "identity"
+	// CredentialsHeader runs tool calls as the MySQL user sent in the
+	// X-MySQL-User and X-MySQL-Password headers.
+	CredentialsHeader = "header"
+)
+
+// Defaults of the per-user connection pools
+const (
+	DefaultMaxUserPools         = 50
+	DefaultUserPoolIdleTimeout  = 10 * time.Minute
+	DefaultUserPoolMaxOpenConns = 4
+)
+
+// Headers carrying the MySQL user in header mode
+const (
+	mysqlUserHeader     = "X-MySQL-User"
+	mysqlPasswordHeader = "X-MySQL-Password"
+)
+
+// dbUser is the MySQL account a tool call runs as.
+type dbUser struct {
+	user     string
+	password string
+}
+
+type dbUserKey struct{}
+
+// dbUserFromContext returns the MySQL account of an HTTP tool call, or nil
+// when it uses the account of its connection.
+func dbUserFromContext(ctx context.Context) *dbUser {
+	u, _ := ctx.Value(dbUserKey{}).(*dbUser)
+	return u
+}
+
+// userPoolKey identifies the pool of a MySQL account on a connection. The
+// password is part of it so that a wrong password cannot use the pool of a
+// right one.
+type userPoolKey struct {
+	connection string
+	user       string
+	password   [sha256.Size]byte
+}
+
+// userPool is the connection pool of one MySQL account.
+type userPool struct {
+	db *sql.DB
+	// inUse counts the sessions holding a connection of the pool
+	inUse    int
+	lastUsed time.Time
+}
+
+// userPools holds the connection pools of the MySQL accounts that HTTP tool
+// calls run as. Their number is bounded; pools unused for idleTimeout are
+// closed, and the least recently used idle pool makes room for the pool of
+// an account that has logged in.
+type userPools struct {
+	mode         string
+	users        map[string]*dbUser
+	maxPools     int
+	idleTimeout  time.Duration
+	maxOpenConns int
+
+	mu    sync.Mutex
+	pools map[userPoolKey]*userPool
+}
+
+func newUserPools(cfg CredentialsConfig) *userPools {
+	p := &userPools{
+		mode:         cfg.Mode,
+		users:        make(map[string]*dbUser, len(cfg.Users)),
+		maxPools:     cfg.MaxPools,
+		idleTimeout:  cfg.IdleTimeout,
+		maxOpenConns: cfg.MaxOpenConns,
+		pools:        make(map[userPoolKey]*userPool),
+	}
+	for name, u := range cfg.Users {
+		p.users[name] = &dbUser{user: u.User, password: u.Password}
+	}
+	return p
+}
+
+// middleware puts the MySQL account of each request into its context. It
+// runs inside the authentication middleware, so that the caller is known.
+// Requests without an account are rejected; they never fall back to the
+// shared one.
+func (p *userPools) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var u *dbUser
+		switch p.mode {
+		case CredentialsIdentity:
+			id := identityFromContext(r.Context())
+			if id == nil {
+				writeHTTPError(w, http.StatusUnauthorized, nil, rpcUnauthorized, "unauthorized: missing credentials")
+				return
+			}
+			if u = p.users[id.name]; u == nil {
+				writeHTTPError(w, http.StatusForbidden, nil, rpcForbidden, fmt.Sprintf("forbidden: no MySQL user is mapped to %s", id.name))
+				return
+			}
+		case CredentialsHeader:
+			u = &dbUser{user: r.Header.Get(mysqlUserHeader), password: r.Header.Get(mysqlPasswordHeader)}
+			if u.user == "" {
+				writeHTTPError(w, http.StatusUnauthorized, nil, rpcUnauthorized, "unauthorized: missing "+mysqlUserHeader+" header")
+				return
+			}
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), dbUserKey{}, u)))
+	})
+}
+
+// acquire returns the pool a tool call on c runs in: the pool of the MySQL
+// account of ctx, or the shared pool of c. The returned function releases
+// the pool when the session ends.
+func (p *userPools) acquire(ctx context.Context, c *dbConnection) (*sql.DB, func(), error) {
+	u := dbUserFromContext(ctx)
+	if u == nil {
+		return c.db, func() {}, nil
+	}
+	key := userPoolKey{connection: c.name, user: u.user, password: sha256.Sum256([]byte(u.password))}
+
+	pool := p.get(key)
+	if pool == nil {
+		// Only accounts that can log in get a pool, so that wrong
+		// credentials cannot push out the pools of working ones
+		db, err := p.open(ctx, c, u)
+		if err != nil {
+			return nil, nil, err
+		}
+		if pool, err = p.add(key, db); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	release := func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		pool.inUse--
+		pool.lastUsed = time.Now()
+	}
+	return pool.db, release, nil
+}
+
+// get returns the pool of key marked as in use, or nil if there is none.
+func (p *userPools) get(key userPoolKey) *userPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.evictIdle(now)
+	pool, ok := p.pools[key]
+	if !ok {
+		return nil
+	}
+	pool.inUse++
+	pool.lastUsed = now
+	return pool
+}
+
+// add stores db as the pool of key and returns it marked as in use. If
+// another call added a pool for key meanwhile, db is closed and that pool
+// is returned instead.
+func (p *userPools) add(key userPoolKey, db *sql.DB) (*userPool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pool, ok := p.pools[key]
+	if ok {
+		db.Close()
+	} else {
+		if len(p.pools) >= p.maxPools && !p.evictOldest() {
+			db.Close()
+			return nil, fmt.Errorf("all %d per-user connection pools are in use; try again later", p.maxPools)
+		}
+		pool = &userPool{db: db}
+		p.pools[key] = pool
+	}
+	pool.inUse++
+	pool.lastUsed = time.Now()
+	return pool, nil
+}
+
+// open opens a pool of c that logs in as u and checks that the login
+// succeeds.
+func (p *userPools) open(ctx context.Context, c *dbConnection, u *dbUser) (*sql.DB, error) {
+	cfg := c.config.Clone()
+	cfg.User = u.user
+	cfg.Passwd = u.password
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database connection %s as %s: %w", c.name, u.user, err)
+	}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(p.maxOpenConns)
+	db.SetConnMaxIdleTime(p.idleTimeout)
+	db.SetConnMaxLifetime(c.profile.ConnMaxLifetime)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to %s as %s: %w", c.name, u.user, err)
+	}
+	return db, nil
+}
+
+// evictIdle closes the pools unused for idleTimeout.
+func (p *userPools) evictIdle(now time.Time) {
+	for key, pool := range p.pools {
+		if pool.inUse == 0 && now.Sub(pool.lastUsed) > p.idleTimeout {
+			pool.db.Close()
+			delete(p.pools, key)
+		}
+	}
+}
+
+// evictOldest closes the least recently used pool without sessions and
+// reports whether there was one.
+func (p *userPools) evictOldest() bool {
+	var oldest *userPoolKey
+	for key, pool := range p.pools {
+		if pool.inUse > 0 {
+			continue
+		}
+		if oldest == nil || pool.lastUsed.Before(p.pools[*oldest].lastUsed) {
+			k := key
+			oldest = &k
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	p.pools[*oldest].db.Close()
+	delete(p.pools, *oldest)
+	return true
+}
+
+// Close closes all pools.
+func (p *userPools) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, pool := range p.pools {
+		pool.db.Close()
+		delete(p.pools, key)
+	}
+}
@@ -0,0 +1,488 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/opcode"
+)
+
+// DefaultCursorTTL is how long an unused cursor stays valid.
+const DefaultCursorTTL = 10 * time.Minute
+
+// Bounds on the memory held by cursors
+const (
+	// maxSessionCursors is the number of cursors kept per client session;
+	// its oldest cursor is dropped first
+	maxSessionCursors = 50
+	// maxCursors is the number of cursors kept for all sessions, a backstop
+	// against many sessions; the oldest cursor is dropped first
+	maxCursors = 1000
+	// maxSnapshotBytes caps the rows read ahead for a snapshot cursor
+	maxSnapshotBytes = 8 << 20
+	// maxSessionSnapshotBytes caps the snapshot rows held for one client
+	// session; its oldest snapshots are dropped first
+	maxSessionSnapshotBytes = 16 << 20
+	// maxTotalSnapshotBytes caps the snapshot rows held for all sessions;
+	// the oldest snapshots are dropped first
+	maxTotalSnapshotBytes = 64 << 20
+)
+
+// queryCursor continues an execute_query result set. A keyset cursor runs
+// the query again starting after the last returned row; a snapshot cursor
+// holds the remaining rows in memory.
+type queryCursor struct {
+	sessionID string
+	// identity is the HTTP caller the cursor belongs to, if any
+	identity string
+	expires  time.Time
+	pageSize int
+	// offset is the number of rows returned so far
+	offset int
+	// query is the statement the cursor continues
+	query string
+	// connection names the database the query runs on
+	connection string
+
+	// Keyset continuation
+	params []any
+	force  bool
+	keyset *keyset
+
+	// Snapshot continuation
+	columnTypes []*sql.ColumnType
+	columns     []columnInfo
+	rows        [][]interface{}
+	// more reports that the query had rows beyond the snapshot
+	more bool
+	// size is the encoded size of rows
+	size int64
+}
+
+// keyset describes ORDER BY columns that form a unique key, and the values
+// of the last row returned.
+type keyset struct {
+	columns []keysetColumn
+	last    []interface{}
+}
+
+// keysetColumn is an ORDER BY column of a keyset cursor.
+type keysetColumn struct {
+	qualifier string
+	name      string
+	desc      bool
+	// index is the position of the column in the result
+	index int
+}
+
+// cursorStore holds the open cursors of all client sessions.
+type cursorStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	cursors map[string]*queryCursor
+	// snapshotBytes is the size of the rows held by all snapshot cursors
+	snapshotBytes int64
+}
+
+func newCursorStore(ttl time.Duration) *cursorStore {
+	return &cursorStore{ttl: ttl, cursors: make(map[string]*queryCursor)}
+}
+
+func sessionIDFromContext(ctx context.Context) string {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		return session.SessionID()
+	}
+	return ""
+}
+
+// put stores c and returns its opaque ID.
+func (cs *cursorStore) put(ctx context.Context, c *queryCursor) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to create cursor: %w", err)
+	}
+	id := hex.EncodeToString(b)
+
+	now := time.Now()
+	c.sessionID = sessionIDFromContext(ctx)
+	c.identity = identityName(ctx)
+	c.expires = now.Add(cs.ttl)
+	c.size = 0
+	for _, row := range c.rows {
+		encoded, err := json.Marshal(row)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode row: %w", err)
+		}
+		c.size += int64(len(encoded))
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for cid, cur := range cs.cursors {
+		if now.After(cur.expires) {
+			cs.drop(cid)
+		}
+	}
+	// Cursors make room for the new one, first within its session
+	sameSession := func(cur *queryCursor) bool { return cur.sessionID == c.sessionID }
+	cs.evict(sameSession, func() bool {
+		return cs.sessionCursors(c.sessionID) >= maxSessionCursors
+	})
+	cs.evict(func(*queryCursor) bool { return true }, func() bool {
+		return len(cs.cursors) >= maxCursors
+	})
+	cs.evict(func(cur *queryCursor) bool { return cur.size > 0 && cur.sessionID == c.sessionID }, func() bool {
+		return c.size > 0 && cs.sessionSnapshotBytes(c.sessionID)+c.size > maxSessionSnapshotBytes
+	})
+	cs.evict(func(cur *queryCursor) bool { return cur.size > 0 }, func() bool {
+		return c.size > 0 && cs.snapshotBytes+c.size > maxTotalSnapshotBytes
+	})
+
+	cs.cursors[id] = c
+	cs.snapshotBytes += c.size
+	return id, nil
+}
+
+// evict drops the cursors matching match that expire first while full
+// reports true. cs.mu must be held.
+func (cs *cursorStore) evict(match func(*queryCursor) bool, full func() bool) {
+	for full() {
+		var oldestID string
+		for id, cur := range cs.cursors {
+			if match(cur) && (oldestID == "" || cur.expires.Before(cs.cursors[oldestID].expires)) {
+				oldestID = id
+			}
+		}
+		if oldestID == "" {
+			return
+		}
+		cs.drop(oldestID)
+	}
+}
+
+// sessionCursors returns the number of cursors of a client session. cs.mu
+// must be held.
+func (cs *cursorStore) sessionCursors(sessionID string) int {
+	n := 0
+	for _, cur := range cs.cursors {
+		if cur.sessionID == sessionID {
+			n++
+		}
+	}
+	return n
+}
+
+// sessionSnapshotBytes returns the size of the snapshot rows held for a
+// client session. cs.mu must be held.
+func (cs *cursorStore) sessionSnapshotBytes(sessionID string) int64 {
+	var size int64
+	for _, cur := range cs.cursors {
+		if cur.sessionID == sessionID {
+			size += cur.size
+		}
+	}
+	return size
+}
+
+// drop removes a cursor. cs.mu must be held.
+func (cs *cursorStore) drop(id string) {
+	if c, ok := cs.cursors[id]; ok {
+		cs.snapshotBytes -= c.size
+		delete(cs.cursors, id)
+	}
+}
+
+// get returns the cursor with the given ID if it belongs to the calling
+// client session and has not expired.
+func (cs *cursorStore) get(ctx context.Context, id string) (*queryCursor, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	c, ok := cs.cursors[id]
+	if !ok || c.sessionID != sessionIDFromContext(ctx) || c.identity != identityName(ctx) {
+		return nil, fmt.Errorf("unknown or expired cursor; run the query again")
+	}
+	if time.Now().After(c.expires) {
+		cs.drop(id)
+		return nil, fmt.Errorf("cursor expired; run the query again")
+	}
+	return c, nil
+}
+
+// remove drops a cursor once it has been continued.
+func (cs *cursorStore) remove(id string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.drop(id)
+}
+
+// keysetCandidate returns the table and ORDER BY columns of a SELECT whose
+// shape allows it to be continued by adding a seek condition: a single
+// table without LIMIT, grouping, DISTINCT, aggregates or window functions,
+// ordered by plain columns that are part of the result.
+func keysetCandidate(stmt ast.StmtNode) (*ast.TableSource, []keysetColumn, bool) {
+	sel, ok := stmt.(*ast.SelectStmt)
+	if !ok || sel.Kind != ast.SelectStmtKindSelect || sel.With != nil || sel.Limit != nil ||
+		sel.GroupBy != nil || sel.Having != nil || sel.Distinct || sel.WindowSpecs != nil ||
+		sel.OrderBy == nil || sel.From == nil || sel.Fields == nil {
+		return nil, nil, false
+	}
+
+	source, ok := sel.From.TableRefs.Left.(*ast.TableSource)
+	if !ok || sel.From.TableRefs.Right != nil {
+		return nil, nil, false
+	}
+	table, ok := source.Source.(*ast.TableName)
+	if !ok {
+		return nil, nil, false
+	}
+	qualifier := table.Name.L
+	if source.AsName.L != "" {
+		qualifier = source.AsName.L
+	}
+
+	checker := &aggregateChecker{}
+	sel.Fields.Accept(checker)
+	if checker.found {
+		return nil, nil, false
+	}
+
+	var columns []keysetColumn
+	for _, item := range sel.OrderBy.Items {
+		col, ok := item.Expr.(*ast.ColumnNameExpr)
+		if !ok || col.Name.Schema.L != "" || (col.Name.Table.L != "" && col.Name.Table.L != qualifier) {
+			return nil, nil, false
+		}
+		if !selectsColumn(sel.Fields.Fields, qualifier, col.Name.Name.L) {
+			return nil, nil, false
+		}
+		columns = append(columns, keysetColumn{qualifier: col.Name.Table.O, name: col.Name.Name.O, desc: item.Desc, index: -1})
+	}
+	return source, columns, true
+}
+
+// selectsColumn reports whether the select list returns the table column
+// name under its own name, and no other field is aliased to that name.
+func selectsColumn(fields []*ast.SelectField, qualifier, name string) bool {
+	found := false
+	for _, field := range fields {
+		if field.WildCard != nil {
+			if field.WildCard.Table.L == "" || field.WildCard.Table.L == qualifier {
+				found = true
+			}
+			continue
+		}
+		col, isColumn := field.Expr.(*ast.ColumnNameExpr)
+		sameColumn := isColumn && col.Name.Name.L == name
+		if field.AsName.L == name && !sameColumn {
+			// ORDER BY would refer to the alias
+			return false
+		}
+		if sameColumn && (field.AsName.L == "" || field.AsName.L == name) {
+			found = true
+		}
+	}
+	return found
+}
+
+// aggregateChecker finds aggregate and window functions.
+type aggregateChecker struct {
+	found bool
+}
+
+func (c *aggregateChecker) Enter(n ast.Node) (ast.Node, bool) {
+	switch n.(type) {
+	case *ast.AggregateFuncExpr, *ast.WindowFuncExpr:
+		c.found = true
+		return n, true
+	case *ast.SubqueryExpr:
+		// Aggregates in subqueries do not group the outer query
+		return n, true
+	}
+	return n, false
+}
+
+func (c *aggregateChecker) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// keysetFor returns the keyset used to page through stmt, or nil when the
+// ORDER BY columns are not NOT NULL integer or string columns covering a
+// unique key of the table.
+func (ms *MySQLServer) keysetFor(ctx context.Context, sess *dbSession, stmt ast.StmtNode) (*keyset, error) {
+	source, columns, ok := keysetCandidate(stmt)
+	if !ok {
+		return nil, nil
+	}
+	table := source.Source.(*ast.TableName)
+	schema := table.Schema.O
+	if schema == "" {
+		schema = sess.database
+	}
+	if schema == "" {
+		return nil, nil
+	}
+
+	rows, err := sess.QueryContext(ctx, `
+		SELECT COLUMN_NAME, IS_NULLABLE, DATA_TYPE
+		FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?`, schema, table.Name.O)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	seekable := make(map[string]bool)
+	for rows.Next() {
+		var name, nullable, dataType string
+		if err := rows.Scan(&name, &nullable, &dataType); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan column: %w", err)
+		}
+		seekable[strings.ToLower(name)] = nullable == "NO" && isKeysetType(dataType)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+
+	ordered := make(map[string]bool)
+	for _, col := range columns {
+		if !seekable[strings.ToLower(col.name)] {
+			return nil, nil
+		}
+		ordered[strings.ToLower(col.name)] = true
+	}
+
+	rows, err = sess.QueryContext(ctx, `
+		SELECT INDEX_NAME, COLUMN_NAME
+		FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND NON_UNIQUE = 0
+		ORDER BY INDEX_NAME, SEQ_IN_INDEX`, schema, table.Name.O)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get indexes: %w", err)
+	}
+	defer rows.Close()
+
+	// An index is usable when all of its columns are in the ORDER BY
+	covered := make(map[string]bool)
+	for rows.Next() {
+		var index string
+		var column sql.NullString
+		if err := rows.Scan(&index, &column); err != nil {
+			return nil, fmt.Errorf("failed to scan index: %w", err)
+		}
+		if _, seen := covered[index]; !seen {
+			covered[index] = true
+		}
+		if !column.Valid || !ordered[strings.ToLower(column.String)] {
+			covered[index] = false
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get indexes: %w", err)
+	}
+
+	for _, ok := range covered {
+		if ok {
+			return &keyset{columns: columns}, nil
+		}
+	}
+	return nil, nil
+}
+
+func isKeysetType(dataType string) bool {
+	switch strings.ToLower(dataType) {
+	case "tinyint", "smallint", "mediumint", "int", "bigint", "char", "varchar":
+		return true
+	}
+	return false
+}
+
+// locate finds the result position of each keyset column.
+func (k *keyset) locate(sources []*columnSource) bool {
+	for i := range k.columns {
+		k.columns[i].index = -1
+		for j, src := range sources {
+			if src != nil && strings.EqualFold(src.Column, k.columns[i].name) {
+				k.columns[i].index = j
+				break
+			}
+		}
+		if k.columns[i].index < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// after returns a copy of k positioned after row, or nil if a key value is
+// not an integer or an untruncated string.
+func (k *keyset) after(row []interface{}) *keyset {
+	last := make([]interface{}, len(k.columns))
+	for i, col := range k.columns {
+		switch v := row[col.index].(type) {
+		case int64, uint64, string:
+			last[i] = v
+		default:
+			return nil
+		}
+	}
+	return &keyset{columns: k.columns, last: last}
+}
+
+// seek adds the condition selecting the rows after the last key to stmt:
+// (a > ?) OR (a = ? AND b > ?) ..., with < for descending columns.
+func (k *keyset) seek(stmt ast.StmtNode) {
+	sel := stmt.(*ast.SelectStmt)
+
+	column := func(col keysetColumn) ast.ExprNode {
+		return &ast.ColumnNameExpr{Name: &ast.ColumnName{Table: ast.NewCIStr(col.qualifier), Name: ast.NewCIStr(col.name)}}
+	}
+
+	var cond ast.ExprNode
+	for i, col := range k.columns {
+		op := opcode.GT
+		if col.desc {
+			op = opcode.LT
+		}
+		var term ast.ExprNode = &ast.BinaryOperationExpr{Op: op, L: column(col), R: ast.NewValueExpr(k.last[i], "", "")}
+		for j := i - 1; j >= 0; j-- {
+			eq := &ast.BinaryOperationExpr{Op: opcode.EQ, L: column(k.columns[j]), R: ast.NewValueExpr(k.last[j], "", "")}
+			term = &ast.BinaryOperationExpr{Op: opcode.LogicAnd, L: eq, R: term}
+		}
+		term = &ast.ParenthesesExpr{Expr: term}
+		if cond == nil {
+			cond = term
+		} else {
+			cond = &ast.BinaryOperationExpr{Op: opcode.LogicOr, L: cond, R: term}
+		}
+	}
+	cond = &ast.ParenthesesExpr{Expr: cond}
+
+	if sel.Where == nil {
+		sel.Where = cond
+	} else {
+		sel.Where = &ast.BinaryOperationExpr{Op: opcode.LogicAnd, L: &ast.ParenthesesExpr{Expr: sel.Where}, R: cond}
+	}
+}
+
+// hasLimit reports whether the outermost query has a LIMIT clause.
+func hasLimit(stmt ast.StmtNode) bool {
+	switch s := stmt.(type) {
+	case *ast.SelectStmt:
+		return s.Limit != nil
+	case *ast.SetOprStmt:
+		return s.Limit != nil
+	}
+	return false
+}
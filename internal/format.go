@@ -0,0 +1,265 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Output formats for tools returning rows
+const (
+	// FormatJSON is the default JSON object result.
+	FormatJSON = "json"
+	// FormatColumnar is JSON with rows as arrays in column order.
+	FormatColumnar = "columnar"
+	// FormatCSV is RFC 4180 CSV with a header row.
+	FormatCSV = "csv"
+	// FormatTSV is tab separated values with a header row.
+	FormatTSV = "tsv"
+	// FormatMarkdown is a Markdown table.
+	FormatMarkdown = "markdown"
+	// FormatNDJSON is one JSON object per row.
+	FormatNDJSON = "ndjson"
+)
+
+// outputFormats lists the accepted values of the format argument.
+var outputFormats = []string{FormatJSON, FormatColumnar, FormatCSV, FormatTSV, FormatMarkdown, FormatNDJSON}
+
+// nullText is how NULL is written in CSV and TSV, as in LOAD DATA and
+// SELECT ... INTO OUTFILE.
+const nullText = `\N`
+
+// getFormatFromArgs returns the validated format argument, or defaultFormat
+// when it is not given.
+func getFormatFromArgs(args map[string]any, defaultFormat string) (string, error) {
+	format, _ := args["format"].(string)
+	return parseFormat(format, defaultFormat)
+}
+
+// parseFormat validates an output format name.
+func parseFormat(format, defaultFormat string) (string, error) {
+	if format == "" {
+		return defaultFormat, nil
+	}
+	for _, f := range outputFormats {
+		if format == f {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("invalid format %q (expected one of %s)", format, strings.Join(outputFormats, ", "))
+}
+
+// formatArgDescription documents the format argument of a tool.
+const formatArgDescription = "Output format: json, columnar ({columns, rows: [[...]]}), csv, tsv, markdown or ndjson. " +
+	"Text formats write NULL as \\N (csv, tsv) or NULL (markdown) and binary values as hex:... or base64:...; " +
+	"the remaining result fields follow as a second JSON content item"
+
+// tableResult renders rows in a format other than FormatJSON. columns are
+// the header names, and meta holds the other fields of the result. For
+// FormatColumnar meta is returned with columns and rows added, so meta must
+// not use either key. For the text formats the table is the first content
+// item and meta, if not empty, the second.
+func tableResult(format string, columns []string, rows [][]interface{}, meta map[string]interface{}) (*mcp.CallToolResult, error) {
+	if rows == nil {
+		rows = [][]interface{}{}
+	}
+
+	var text string
+	switch format {
+	case FormatColumnar:
+		result := make(map[string]interface{}, len(meta)+2)
+		for k, v := range meta {
+			result[k] = v
+		}
+		result["columns"] = columns
+		result["rows"] = rows
+		return jsonResult(result)
+	case FormatCSV:
+		text = formatDelimited(columns, rows, ',', csvField)
+	case FormatTSV:
+		text = formatDelimited(columns, rows, '\t', tsvField)
+	case FormatMarkdown:
+		text = formatMarkdown(columns, rows)
+	case FormatNDJSON:
+		var err error
+		if text, err = formatNDJSON(columns, rows); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+
+	result := mcp.NewToolResultText(text)
+	if len(meta) > 0 {
+		metaJSON, err := json.Marshal(meta)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal result: %w", err)
+		}
+		result.Content = append(result.Content, mcp.NewTextContent(string(metaJSON)))
+	}
+	return result, nil
+}
+
+// cellText converts an encoded value to text for the text formats. ok is
+// false for NULL.
+func cellText(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return val, true
+	case int64:
+		return strconv.FormatInt(val, 10), true
+	case uint64:
+		return strconv.FormatUint(val, 10), true
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64), true
+	case bool:
+		return strconv.FormatBool(val), true
+	case json.Number:
+		return val.String(), true
+	case json.RawMessage:
+		return string(val), true
+	case map[string]interface{}:
+		// Values cut off by the response budget
+		if prefix, ok := val["$truncated"].(string); ok {
+			if val["$encoding"] == "base64" {
+				prefix = "base64:" + prefix
+			}
+			return fmt.Sprintf("%s...[truncated, %v bytes]", prefix, val["$length"]), true
+		}
+	case map[string]string:
+		// Binary values from encodeBinary
+		if h, ok := val["$hex"]; ok {
+			return "hex:" + h, true
+		}
+		if b, ok := val["$base64"]; ok {
+			return "base64:" + b, true
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v), true
+	}
+	return string(b), true
+}
+
+func formatDelimited(columns []string, rows [][]interface{}, sep byte, field func(interface{}) string) string {
+	var buf bytes.Buffer
+	for i, col := range columns {
+		if i > 0 {
+			buf.WriteByte(sep)
+		}
+		buf.WriteString(field(col))
+	}
+	buf.WriteByte('\n')
+	for _, row := range rows {
+		for i, v := range row {
+			if i > 0 {
+				buf.WriteByte(sep)
+			}
+			buf.WriteString(field(v))
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// csvField quotes a CSV field when needed. A string that reads as the NULL
+// marker is quoted so the two stay distinct.
+func csvField(v interface{}) string {
+	s, ok := cellText(v)
+	if !ok {
+		return nullText
+	}
+	if s == nullText || strings.ContainsAny(s, ",\"\r\n") {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return s
+}
+
+// tsvEscaper escapes TSV fields the way MySQL writes them with
+// SELECT ... INTO OUTFILE, so a literal \N is written as \\N.
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\x00", `\0`)
+
+func tsvField(v interface{}) string {
+	s, ok := cellText(v)
+	if !ok {
+		return nullText
+	}
+	return tsvEscaper.Replace(s)
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\r\n", "<br>", "\n", "<br>", "\r", "<br>")
+
+func formatMarkdown(columns []string, rows [][]interface{}) string {
+	var buf bytes.Buffer
+	writeRow := func(cells []string) {
+		buf.WriteString("|")
+		for _, c := range cells {
+			buf.WriteString(" ")
+			buf.WriteString(c)
+			buf.WriteString(" |")
+		}
+		buf.WriteString("\n")
+	}
+
+	header := make([]string, len(columns))
+	separator := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = markdownEscaper.Replace(col)
+		separator[i] = "---"
+	}
+	writeRow(header)
+	writeRow(separator)
+
+	cells := make([]string, len(columns))
+	for _, row := range rows {
+		for i, v := range row {
+			s, ok := cellText(v)
+			if !ok {
+				s = "NULL"
+			}
+			cells[i] = markdownEscaper.Replace(s)
+		}
+		writeRow(cells)
+	}
+	return buf.String()
+}
+
+// formatNDJSON writes each row as an object keyed by column name, with the
+// keys in column order. Values keep their JSON encoding, including binary
+// marker objects.
+func formatNDJSON(columns []string, rows [][]interface{}) (string, error) {
+	keys := make([][]byte, len(columns))
+	for i, col := range columns {
+		key, err := json.Marshal(col)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal column name: %w", err)
+		}
+		keys[i] = key
+	}
+
+	var buf bytes.Buffer
+	for _, row := range rows {
+		buf.WriteByte('{')
+		for i, v := range row {
+			value, err := json.Marshal(v)
+			if err != nil {
+				return "", fmt.Errorf("failed to marshal row: %w", err)
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(keys[i])
+			buf.WriteByte(':')
+			buf.Write(value)
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.String(), nil
+}
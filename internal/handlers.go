@@ -4,33 +4,66 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/pingcap/tidb/pkg/parser/ast"
 )
 
 func (ms *MySQLServer) listSchemasHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	args := request.GetArguments()
-	page := getIntFromArgs(args, "page", 1)
-	pageSize := getIntFromArgs(args, "page_size", DefaultPageSize)
+	access := ms.accessFor(ctx)
+	opts, err := ms.getListOptionsFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := getFormatFromArgs(args, ms.output.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ms.getConnectionFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, sess, err := ms.beginSession(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	conds, condArgs := opts.filter("SCHEMA_NAME")
+	accessConds, accessArgs := access.schemaFilter("SCHEMA_NAME")
+	conds = append(conds, accessConds...)
+	condArgs = append(condArgs, accessArgs...)
 
-	if pageSize > MaxPageSize {
-		pageSize = MaxPageSize
+	result := map[string]interface{}{
+		"page_size": opts.pageSize,
 	}
 
-	offset := (page - 1) * pageSize
+	// Counting is optional as it scans all schemas
+	if opts.includeTotal {
+		var totalCount int
+		countQuery := "SELECT COUNT(*) FROM information_schema.SCHEMATA " + sqlWhere(conds)
+		if err := sess.QueryRowContext(ctx, countQuery, condArgs...).Scan(&totalCount); err != nil {
+			return nil, fmt.Errorf("failed to get schema count: %w", err)
+		}
+		result["total_count"] = totalCount
+	}
 
-	// Get total count
-	var totalCount int
-	countQuery := "SELECT COUNT(*) FROM information_schema.SCHEMATA"
-	if err := ms.db.QueryRow(countQuery).Scan(&totalCount); err != nil {
-		return nil, fmt.Errorf("failed to get schema count: %w", err)
+	// Continue after the last schema of the previous page
+	if opts.after != "" {
+		conds = append(conds, "SCHEMA_NAME > ?")
+		condArgs = append(condArgs, opts.after)
 	}
 
-	// Get schemas with pagination
-	query := "SELECT SCHEMA_NAME FROM information_schema.SCHEMATA ORDER BY SCHEMA_NAME LIMIT ? OFFSET ?"
-	rows, err := ms.db.Query(query, pageSize, offset)
+	// Fetch one extra row to tell whether there is another page
+	query := "SELECT SCHEMA_NAME FROM information_schema.SCHEMATA " + sqlWhere(conds) + " ORDER BY SCHEMA_NAME LIMIT ?"
+	rows, err := sess.QueryContext(ctx, query, append(condArgs, opts.pageSize+1)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list schemas: %w", err)
 	}
@@ -44,66 +77,136 @@ func (ms *MySQLServer) listSchemasHandler(ctx context.Context, request mcp.CallT
 		}
 		schemas = append(schemas, schema)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list schemas: %w", err)
+	}
 
-	result := map[string]interface{}{
-		"schemas":     schemas,
-		"page":        page,
-		"page_size":   pageSize,
-		"total_count": totalCount,
-		"total_pages": (totalCount + pageSize - 1) / pageSize,
+	if len(schemas) > opts.pageSize {
+		schemas = schemas[:opts.pageSize]
+		result["next_cursor"] = nextCursor(schemas[len(schemas)-1])
 	}
+	result["count"] = len(schemas)
 
+	if format != FormatJSON {
+		schemaRows := make([][]interface{}, len(schemas))
+		for i, schema := range schemas {
+			schemaRows[i] = []interface{}{schema}
+		}
+		return tableResult(format, []string{"schema"}, schemaRows, result)
+	}
+
+	result["schemas"] = schemas
 	return jsonResult(result)
 }
 
+// tableColumns are the fields of a list_tables entry, in output order.
+var tableColumns = []string{"name", "type", "engine", "rows", "data_size", "index_size", "created_at", "updated_at"}
+
 func (ms *MySQLServer) listTablesHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	args := request.GetArguments()
+	access := ms.accessFor(ctx)
 	schema, ok := args["schema"].(string)
 	if !ok || schema == "" {
 		return nil, fmt.Errorf("schema parameter is required")
 	}
 
-	page := getIntFromArgs(args, "page", 1)
-	pageSize := getIntFromArgs(args, "page_size", DefaultPageSize)
+	if !access.schemaAllowed(schema) {
+		return nil, notAccessibleError(schema, "")
+	}
+
+	opts, err := ms.getListOptionsFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	tableType, err := getTableTypeFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := getFormatFromArgs(args, ms.output.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ms.getConnectionFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, sess, err := ms.beginSession(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
 
-	if pageSize > MaxPageSize {
-		pageSize = MaxPageSize
+	conds := []string{"TABLE_SCHEMA = ?"}
+	condArgs := []any{schema}
+	if tableType != "" {
+		conds = append(conds, "TABLE_TYPE = ?")
+		condArgs = append(condArgs, tableType)
 	}
+	nameConds, nameArgs := opts.filter("TABLE_NAME")
+	conds = append(conds, nameConds...)
+	condArgs = append(condArgs, nameArgs...)
+	accessConds, accessArgs := access.tableFilter("TABLE_SCHEMA", "TABLE_NAME")
+	conds = append(conds, accessConds...)
+	condArgs = append(condArgs, accessArgs...)
 
-	offset := (page - 1) * pageSize
+	result := map[string]interface{}{
+		"schema":    schema,
+		"page_size": opts.pageSize,
+	}
 
-	// Get total count
-	var totalCount int
-	countQuery := "SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ?"
-	if err := ms.db.QueryRow(countQuery, schema).Scan(&totalCount); err != nil {
-		return nil, fmt.Errorf("failed to get table count: %w", err)
+	// Counting is optional as it scans all tables of the schema
+	if opts.includeTotal {
+		var totalCount int
+		countQuery := "SELECT COUNT(*) FROM information_schema.TABLES " + sqlWhere(conds)
+		if err := sess.QueryRowContext(ctx, countQuery, condArgs...).Scan(&totalCount); err != nil {
+			return nil, fmt.Errorf("failed to get table count: %w", err)
+		}
+		result["total_count"] = totalCount
+	}
+
+	// Continue after the last table of the previous page
+	if opts.after != "" {
+		conds = append(conds, "TABLE_NAME > ?")
+		condArgs = append(condArgs, opts.after)
 	}
 
-	// Get tables with pagination
+	// Fetch one extra row to tell whether there is another page
 	query := `
-		SELECT TABLE_NAME, TABLE_TYPE, ENGINE, TABLE_ROWS, 
+		SELECT TABLE_NAME, TABLE_TYPE, ENGINE, TABLE_ROWS,
 		       DATA_LENGTH, INDEX_LENGTH, CREATE_TIME, UPDATE_TIME
-		FROM information_schema.TABLES 
-		WHERE TABLE_SCHEMA = ?
-		ORDER BY TABLE_NAME 
-		LIMIT ? OFFSET ?
+		FROM information_schema.TABLES
+		` + sqlWhere(conds) + `
+		ORDER BY TABLE_NAME
+		LIMIT ?
 	`
-	rows, err := ms.db.Query(query, schema, pageSize, offset)
+	rows, err := sess.QueryContext(ctx, query, append(condArgs, opts.pageSize+1)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list tables: %w", err)
 	}
 	defer rows.Close()
 
 	var tables []map[string]interface{}
+	var tableRows [][]interface{}
+	var lastName string
 	for rows.Next() {
+		if len(tables) == opts.pageSize {
+			result["next_cursor"] = nextCursor(lastName)
+			break
+		}
+
 		var tableName, tableType string
 		var engine, createTime, updateTime sql.NullString
-		var tableRows, dataLength, indexLength sql.NullInt64
+		var rowCount, dataLength, indexLength sql.NullInt64
 
-		if err := rows.Scan(&tableName, &tableType, &engine, &tableRows, 
+		if err := rows.Scan(&tableName, &tableType, &engine, &rowCount,
 			&dataLength, &indexLength, &createTime, &updateTime); err != nil {
 			return nil, fmt.Errorf("failed to scan table info: %w", err)
 		}
+		lastName = tableName
 
 		table := map[string]interface{}{
 			"name": tableName,
@@ -113,8 +216,8 @@ func (ms *MySQLServer) listTablesHandler(ctx context.Context, request mcp.CallTo
 		if engine.Valid {
 			table["engine"] = engine.String
 		}
-		if tableRows.Valid {
-			table["rows"] = tableRows.Int64
+		if rowCount.Valid {
+			table["rows"] = rowCount.Int64
 		}
 		if dataLength.Valid {
 			table["data_size"] = dataLength.Int64
@@ -130,22 +233,29 @@ func (ms *MySQLServer) listTablesHandler(ctx context.Context, request mcp.CallTo
 		}
 
 		tables = append(tables, table)
+
+		row := make([]interface{}, len(tableColumns))
+		for i, col := range tableColumns {
+			row[i] = table[col]
+		}
+		tableRows = append(tableRows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
 	}
+	result["count"] = len(tables)
 
-	result := map[string]interface{}{
-		"schema":      schema,
-		"tables":      tables,
-		"page":        page,
-		"page_size":   pageSize,
-		"total_count": totalCount,
-		"total_pages": (totalCount + pageSize - 1) / pageSize,
+	if format != FormatJSON {
+		return tableResult(format, tableColumns, tableRows, result)
 	}
 
+	result["tables"] = tables
 	return jsonResult(result)
 }
 
 func (ms *MySQLServer) getTableCreateHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	args := request.GetArguments()
+	access := ms.accessFor(ctx)
 	schema, ok := args["schema"].(string)
 	if !ok || schema == "" {
 		return nil, fmt.Errorf("schema parameter is required")
@@ -156,17 +266,45 @@ func (ms *MySQLServer) getTableCreateHandler(ctx context.Context, request mcp.Ca
 		return nil, fmt.Errorf("table parameter is required")
 	}
 
+	if !access.tableAllowed(schema, table) {
+		return nil, notAccessibleError(schema, table)
+	}
+
+	conn, err := ms.getConnectionFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, sess, err := ms.beginSession(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	// The statement would reveal restricted columns
+	if access.hasColumnRules() {
+		columns, err := tableColumnNames(ctx, sess, schema, table)
+		if err != nil {
+			return nil, err
+		}
+		for _, col := range columns {
+			if !access.columnAllowed(schema, table, col) {
+				return nil, fmt.Errorf("%s.%s has restricted columns, use get_table_structure instead", schema, table)
+			}
+		}
+	}
+
 	// Get CREATE TABLE statement
 	var tableName, createStmt string
-	query := fmt.Sprintf("SHOW CREATE TABLE `%s`.`%s`", schema, table)
-	err := ms.db.QueryRow(query).Scan(&tableName, &createStmt)
+	query := fmt.Sprintf("SHOW CREATE TABLE %s.%s", quoteIdentifier(schema), quoteIdentifier(table))
+	err = sess.QueryRowContext(ctx, query).Scan(&tableName, &createStmt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get create statement: %w", err)
 	}
 
 	result := map[string]interface{}{
-		"schema":          schema,
-		"table":           table,
+		"schema":           schema,
+		"table":            table,
 		"create_statement": createStmt,
 	}
 
@@ -180,69 +318,289 @@ func (ms *MySQLServer) executeQueryHandler(ctx context.Context, request mcp.Call
 		return nil, fmt.Errorf("query parameter is required")
 	}
 
-	// Basic safety check - only allow SELECT statements
-	trimmedQuery := strings.TrimSpace(strings.ToUpper(query))
-	if !strings.HasPrefix(trimmedQuery, "SELECT") && !strings.HasPrefix(trimmedQuery, "SHOW") && 
-	   !strings.HasPrefix(trimmedQuery, "DESCRIBE") && !strings.HasPrefix(trimmedQuery, "EXPLAIN") {
-		return nil, fmt.Errorf("only SELECT, SHOW, DESCRIBE, and EXPLAIN statements are allowed")
+	// Safety check - parse the query and only allow side-effect free reads
+	stmt, err := parseReadOnlyStatement(query)
+	if err != nil {
+		return nil, err
 	}
 
-	limit := getIntFromArgs(args, "limit", 100)
+	// Bind ? placeholders from the params argument
+	params, err := parseQueryParams(args["params"])
+	if err != nil {
+		return nil, err
+	}
+	if markers := len(paramMarkers(stmt)); markers != len(params) {
+		return nil, fmt.Errorf("query has %d placeholder(s) but %d param(s) were given", markers, len(params))
+	}
 
-	// Add LIMIT if not present and it's a SELECT query
-	if strings.HasPrefix(trimmedQuery, "SELECT") && !strings.Contains(trimmedQuery, "LIMIT") {
-		query = fmt.Sprintf("%s LIMIT %d", query, limit)
+	format, rowFormat, err := ms.getResultFormatFromArgs(args)
+	if err != nil {
+		return nil, err
 	}
 
-	rows, err := ms.db.Query(query)
+	limit := ms.getQueryLimitFromArgs(args, ms.paging.DefaultQueryLimit)
+	force := getBoolFromArgs(args, "force", false)
+
+	conn, err := ms.getConnectionFromArgs(args)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute query: %w", err)
+		return nil, err
 	}
-	defer rows.Close()
 
-	// Get column names
-	columns, err := rows.Columns()
+	ctx, sess, err := ms.beginSession(ctx, conn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get columns: %w", err)
+		return nil, err
 	}
+	defer sess.Close()
 
-	// Prepare result
-	var results []map[string]interface{}
-	values := make([]interface{}, len(columns))
-	valuePtrs := make([]interface{}, len(columns))
+	if err := ms.checkStatementAccess(ctx, sess, stmt); err != nil {
+		return nil, err
+	}
+	masks, err := ms.maskPlanFor(ctx, sess, stmt)
+	if err != nil {
+		return nil, err
+	}
 
-	for rows.Next() {
-		for i := range columns {
-			valuePtrs[i] = &values[i]
+	// A result set without a LIMIT of its own can be continued with a
+	// cursor: by seeking past the last row when it is ordered by a unique
+	// key, otherwise by reading ahead and holding the remaining rows
+	ks, err := ms.keysetFor(ctx, sess, stmt)
+	if err != nil {
+		return nil, err
+	}
+	if ks != nil && masks.masksAny(ks.columns) {
+		// Masked values cannot be sought past
+		ks = nil
+	}
+	snapshot := ks == nil && isPlannable(stmt) && !hasLimit(stmt)
+
+	readLimit, maxBytes := limit, ms.budget.rowBytes()
+	if snapshot {
+		readLimit, maxBytes = ms.paging.MaxQueryLimit, maxSnapshotBytes
+	}
+	rs, rowLimit, err := ms.runQuery(ctx, sess, stmt, query, params, readLimit, maxBytes, force, masks)
+	if err != nil {
+		var rejected *costRejectedError
+		if errors.As(err, &rejected) {
+			return rejected.result()
 		}
+		return nil, err
+	}
+	if !snapshot {
+		// The query's own LIMIT decides how many rows are returned
+		limit = rowLimit
+	}
 
-		if err := rows.Scan(valuePtrs...); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
+	page, rest, err := ms.budget.page(rs, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := resultColumnSources(stmt, sess.database, columnNames(rs.columnTypes))
+	columns := describeColumns(rs.columnTypes, sources, rs.masked)
+
+	var cursor *queryCursor
+	switch {
+	case ks != nil && page.truncated && len(page.rows) > 0 && ks.locate(sources):
+		if next := ks.after(page.rows[len(page.rows)-1]); next != nil {
+			cursor = &queryCursor{query: query, params: params, force: force, keyset: next}
 		}
+	case snapshot && len(rest) > 0:
+		cursor = &queryCursor{query: query, columnTypes: rs.columnTypes, columns: columns, rows: rest, more: rs.truncated}
+	}
+	if cursor != nil {
+		cursor.connection = conn.name
+		cursor.pageSize = limit
+		cursor.offset = len(page.rows)
+	}
 
-		row := make(map[string]interface{})
-		for i, col := range columns {
-			val := values[i]
-			if b, ok := val.([]byte); ok {
-				row[col] = string(b)
-			} else {
-				row[col] = val
-			}
+	return ms.queryResult(ctx, format, rowFormat, page, columns, cursor)
+}
+
+func (ms *MySQLServer) fetchMoreHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	id, ok := args["cursor"].(string)
+	if !ok || id == "" {
+		return nil, fmt.Errorf("cursor parameter is required")
+	}
+
+	format, rowFormat, err := ms.getResultFormatFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := ms.cursors.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if name, _ := args["connection"].(string); name != "" && name != c.connection {
+		return nil, fmt.Errorf("cursor belongs to connection %q, not %q", c.connection, name)
+	}
+	auditSQL(ctx, c.query)
+	limit := ms.getQueryLimitFromArgs(args, c.pageSize)
+
+	// Snapshot cursors page through the rows held in memory
+	if c.keyset == nil {
+		rs := &resultSet{columnTypes: c.columnTypes, rows: c.rows, truncated: c.more, offset: c.offset}
+		page, rest, err := ms.budget.page(rs, limit)
+		if err != nil {
+			return nil, err
+		}
+		var next *queryCursor
+		if len(rest) > 0 {
+			next = &queryCursor{pageSize: c.pageSize, offset: c.offset + len(page.rows), query: c.query, connection: c.connection,
+				columnTypes: c.columnTypes, columns: c.columns, rows: rest, more: c.more}
+		}
+		ms.cursors.remove(id)
+		return ms.queryResult(ctx, format, rowFormat, page, c.columns, next)
+	}
+
+	// Keyset cursors run the query again after the last row returned
+	stmt, err := parseReadOnlyStatement(c.query)
+	if err != nil {
+		return nil, err
+	}
+	c.keyset.seek(stmt)
+	params := append([]any(nil), c.params...)
+
+	conn, err := ms.connection(c.connection)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, sess, err := ms.beginSession(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	masks, err := ms.maskPlanFor(ctx, sess, stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, _, err := ms.runQuery(ctx, sess, stmt, c.query, params, limit, ms.budget.rowBytes(), c.force, masks)
+	if err != nil {
+		var rejected *costRejectedError
+		if errors.As(err, &rejected) {
+			return rejected.result()
+		}
+		return nil, err
+	}
+	rs.offset = c.offset
+
+	page, _, err := ms.budget.page(rs, limit)
+	if err != nil {
+		return nil, err
+	}
+	columns := describeColumns(rs.columnTypes, resultColumnSources(stmt, sess.database, columnNames(rs.columnTypes)), rs.masked)
+
+	var next *queryCursor
+	if page.truncated && len(page.rows) > 0 {
+		if ks := c.keyset.after(page.rows[len(page.rows)-1]); ks != nil {
+			next = &queryCursor{pageSize: c.pageSize, offset: c.offset + len(page.rows),
+				query: c.query, connection: c.connection, params: c.params, force: c.force, keyset: ks}
+		}
+	}
+	ms.cursors.remove(id)
+	return ms.queryResult(ctx, format, rowFormat, page, columns, next)
+}
+
+// runQuery enforces the row limit and execution time hint on stmt, checks
+// its estimated cost unless forced and reads its rows, masked by masks. It
+// returns the rows and the number of rows the query is limited to.
+func (ms *MySQLServer) runQuery(ctx context.Context, sess *dbSession, stmt ast.StmtNode, query string, params []any, limit int, maxBytes int64, force bool, masks *maskPlan) (*resultSet, int, error) {
+	// Enforce the row limit on the outermost query
+	limit, limited, err := limitRows(stmt, limit, ms.paging.MaxQueryLimit, params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Let the server abort the statement itself if it runs past the timeout
+	hinted := addExecutionTimeHint(stmt, ms.queryTimeout)
+
+	if limited || hinted {
+		if query, err = restoreStatement(stmt); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// Refuse expensive statements before running them unless forced
+	if ms.costGuardEnabled() && isPlannable(stmt) && !force {
+		plan, err := explainQuery(ctx, sess, query, params...)
+		if err != nil {
+			return nil, 0, err
+		}
+		if reasons := ms.checkQueryCost(plan); len(reasons) > 0 {
+			return nil, 0, &costRejectedError{reasons: reasons, plan: plan}
 		}
-		results = append(results, row)
 	}
 
+	rows, err := sess.QueryContext(ctx, query, params...)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, 0, fmt.Errorf("query timed out after %s", ms.queryTimeout)
+		}
+		return nil, 0, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	rs, err := sess.readRows(rows, limit, maxBytes, masks)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rs, limit, nil
+}
+
+// queryResult renders one page of a query result. A non-nil cursor is
+// stored and returned so the client can fetch the following rows.
+func (ms *MySQLServer) queryResult(ctx context.Context, format, rowFormat string, page *resultSet, columns []columnInfo, cursor *queryCursor) (*mcp.CallToolResult, error) {
+	auditRows(ctx, len(page.rows))
+	usageRows(ctx, len(page.rows))
+
 	result := map[string]interface{}{
-		"columns": columns,
-		"rows":    results,
-		"count":   len(results),
+		"columns":   columns,
+		"count":     len(page.rows),
+		"truncated": page.truncated,
+	}
+	if cursor != nil {
+		id, err := ms.cursors.put(ctx, cursor)
+		if err != nil {
+			return nil, err
+		}
+		result["cursor"] = id
+	}
+	if hint := truncationHint(page, ms.budget, cursor != nil); hint != "" {
+		result["hint"] = hint
+	}
+
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.Name
+	}
+
+	if format == FormatColumnar {
+		// The header holds the names, the metadata moves to its own key
+		result["column_info"] = columns
+		delete(result, "columns")
+	}
+	if format != FormatJSON {
+		return tableResult(format, names, page.rows, result)
 	}
 
+	// Arrays keep every value when several columns share a name
+	var results interface{} = page.rows
+	if rowFormat == RowFormatObject {
+		results = rowObjects(names, page.rows)
+	}
+	result["row_format"] = rowFormat
+	result["rows"] = results
+
 	return jsonResult(result)
 }
 
 func (ms *MySQLServer) searchTableHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	args := request.GetArguments()
+	access := ms.accessFor(ctx)
 	schema, ok := args["schema"].(string)
 	if !ok || schema == "" {
 		return nil, fmt.Errorf("schema parameter is required")
@@ -258,7 +616,27 @@ func (ms *MySQLServer) searchTableHandler(ctx context.Context, request mcp.CallT
 		return nil, fmt.Errorf("search_term parameter is required")
 	}
 
-	limit := getIntFromArgs(args, "limit", 100)
+	limit := ms.getQueryLimitFromArgs(args, ms.paging.DefaultQueryLimit)
+
+	format, err := getFormatFromArgs(args, ms.output.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	if !access.tableAllowed(schema, table) {
+		return nil, notAccessibleError(schema, table)
+	}
+
+	conn, err := ms.getConnectionFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, sess, err := ms.beginSession(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
 
 	// First, get all columns for the table
 	colQuery := `
@@ -267,7 +645,7 @@ func (ms *MySQLServer) searchTableHandler(ctx context.Context, request mcp.CallT
 		WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?
 		ORDER BY ORDINAL_POSITION
 	`
-	colRows, err := ms.db.Query(colQuery, schema, table)
+	colRows, err := sess.QueryContext(ctx, colQuery, schema, table)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get columns: %w", err)
 	}
@@ -280,11 +658,14 @@ func (ms *MySQLServer) searchTableHandler(ctx context.Context, request mcp.CallT
 		if err := colRows.Scan(&colName, &dataType); err != nil {
 			return nil, fmt.Errorf("failed to scan column: %w", err)
 		}
+		if !access.columnAllowed(schema, table, colName) {
+			continue
+		}
 		columns = append(columns, colName)
-		
+
 		// Only search in text-like columns
 		if isSearchableType(dataType) {
-			searchableColumns = append(searchableColumns, fmt.Sprintf("`%s` LIKE ?", colName))
+			searchableColumns = append(searchableColumns, quoteIdentifier(colName)+" LIKE ?")
 		}
 	}
 
@@ -294,7 +675,11 @@ func (ms *MySQLServer) searchTableHandler(ctx context.Context, request mcp.CallT
 
 	// Build search query
 	whereClause := strings.Join(searchableColumns, " OR ")
-	searchQuery := fmt.Sprintf("SELECT * FROM `%s`.`%s` WHERE %s LIMIT %d", schema, table, whereClause, limit)
+	selectList := make([]string, len(columns))
+	for i, col := range columns {
+		selectList[i] = quoteIdentifier(col)
+	}
+	searchQuery := fmt.Sprintf("SELECT %s FROM %s.%s WHERE %s LIMIT %d", strings.Join(selectList, ", "), quoteIdentifier(schema), quoteIdentifier(table), whereClause, limit)
 
 	// Prepare search parameters
 	searchPattern := fmt.Sprintf("%%%s%%", searchTerm)
@@ -303,52 +688,55 @@ func (ms *MySQLServer) searchTableHandler(ctx context.Context, request mcp.CallT
 		params[i] = searchPattern
 	}
 
-	rows, err := ms.db.Query(searchQuery, params...)
+	masks, err := ms.tableMaskPlan(ctx, sess, schema, table)
+	if err != nil {
+		return nil, err
+	}
+
+	auditSQL(ctx, searchQuery)
+	rows, err := sess.QueryContext(ctx, searchQuery, params...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search table: %w", err)
 	}
 	defer rows.Close()
 
-	// Prepare result
-	var results []map[string]interface{}
-	values := make([]interface{}, len(columns))
-	valuePtrs := make([]interface{}, len(columns))
-
-	for rows.Next() {
-		for i := range columns {
-			valuePtrs[i] = &values[i]
-		}
+	rs, err := sess.scanRows(rows, limit, masks)
+	if err != nil {
+		return nil, err
+	}
+	values := rs.rows
+	auditRows(ctx, len(values))
+	usageRows(ctx, len(values))
 
-		if err := rows.Scan(valuePtrs...); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
-		}
+	resultColumns := columnNames(rs.columnTypes)
+	results := rowObjects(resultColumns, values)
 
-		row := make(map[string]interface{})
-		for i, col := range columns {
-			val := values[i]
-			if b, ok := val.([]byte); ok {
-				row[col] = string(b)
-			} else {
-				row[col] = val
-			}
-		}
-		results = append(results, row)
+	result := map[string]interface{}{
+		"schema":           schema,
+		"table":            table,
+		"search_term":      searchTerm,
+		"searched_columns": columns,
+		"count":            len(results),
+		"truncated":        rs.truncated,
+	}
+	if hint := truncationHint(rs, ms.budget, false); hint != "" {
+		result["hint"] = hint
+	}
+	if masked := maskedColumns(resultColumns, rs.masked); len(masked) > 0 {
+		result["masked"] = masked
 	}
 
-	result := map[string]interface{}{
-		"schema":      schema,
-		"table":       table,
-		"search_term": searchTerm,
-		"columns":     columns,
-		"rows":        results,
-		"count":       len(results),
+	if format != FormatJSON {
+		return tableResult(format, resultColumns, values, result)
 	}
 
+	result["rows"] = results
 	return jsonResult(result)
 }
 
 func (ms *MySQLServer) getTableStructureHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	args := request.GetArguments()
+	access := ms.accessFor(ctx)
 	schema, ok := args["schema"].(string)
 	if !ok || schema == "" {
 		return nil, fmt.Errorf("schema parameter is required")
@@ -359,43 +747,42 @@ func (ms *MySQLServer) getTableStructureHandler(ctx context.Context, request mcp
 		return nil, fmt.Errorf("table parameter is required")
 	}
 
-	// Get column information
-	colQuery := `
-		SELECT COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_KEY, 
-		       COLUMN_DEFAULT, EXTRA, COLUMN_COMMENT
-		FROM information_schema.COLUMNS 
-		WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?
-		ORDER BY ORDINAL_POSITION
-	`
-	rows, err := ms.db.Query(colQuery, schema, table)
+	if !access.tableAllowed(schema, table) {
+		return nil, notAccessibleError(schema, table)
+	}
+
+	conn, err := ms.getConnectionFromArgs(args)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get columns: %w", err)
+		return nil, err
 	}
-	defer rows.Close()
 
-	var columns []map[string]interface{}
-	for rows.Next() {
-		var colName, colType, isNullable, colKey, extra, comment string
-		var colDefault sql.NullString
+	ctx, sess, err := ms.beginSession(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
 
-		if err := rows.Scan(&colName, &colType, &isNullable, &colKey, 
-			&colDefault, &extra, &comment); err != nil {
-			return nil, fmt.Errorf("failed to scan column: %w", err)
-		}
+	// Get column information
+	tableColumns, err := ms.tableColumns(ctx, sess, schema, table)
+	if err != nil {
+		return nil, err
+	}
 
+	var columns []map[string]interface{}
+	for _, col := range tableColumns {
 		column := map[string]interface{}{
-			"name":     colName,
-			"type":     colType,
-			"nullable": isNullable == "YES",
-			"key":      colKey,
-			"extra":    extra,
+			"name":     col.name,
+			"type":     col.columnType,
+			"nullable": col.nullable,
+			"key":      col.key,
+			"extra":    col.extra,
 		}
 
-		if colDefault.Valid {
-			column["default"] = colDefault.String
+		if col.defaultValue.Valid {
+			column["default"] = col.defaultValue.String
 		}
-		if comment != "" {
-			column["comment"] = comment
+		if col.comment != "" {
+			column["comment"] = col.comment
 		}
 
 		columns = append(columns, column)
@@ -408,7 +795,7 @@ func (ms *MySQLServer) getTableStructureHandler(ctx context.Context, request mcp
 		WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?
 		GROUP BY INDEX_NAME, NON_UNIQUE
 	`
-	indexRows, err := ms.db.Query(indexQuery, schema, table)
+	indexRows, err := sess.QueryContext(ctx, indexQuery, schema, table)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get indexes: %w", err)
 	}
@@ -423,10 +810,21 @@ func (ms *MySQLServer) getTableStructureHandler(ctx context.Context, request mcp
 			return nil, fmt.Errorf("failed to scan index: %w", err)
 		}
 
+		// Leave out restricted columns, and indexes made up of them only
+		var indexColumns []string
+		for _, col := range strings.Split(columns, ",") {
+			if access.columnAllowed(schema, table, col) {
+				indexColumns = append(indexColumns, col)
+			}
+		}
+		if len(indexColumns) == 0 {
+			continue
+		}
+
 		index := map[string]interface{}{
 			"name":    indexName,
 			"unique":  nonUnique == 0,
-			"columns": strings.Split(columns, ","),
+			"columns": indexColumns,
 		}
 		indexes = append(indexes, index)
 	}
@@ -442,6 +840,122 @@ func (ms *MySQLServer) getTableStructureHandler(ctx context.Context, request mcp
 }
 
 // Helper functions
+
+// tableColumn describes a table column as listed in information_schema.
+type tableColumn struct {
+	name         string
+	columnType   string
+	dataType     string
+	nullable     bool
+	key          string
+	defaultValue sql.NullString
+	extra        string
+	comment      string
+}
+
+// tableColumns returns the columns of a table in order, leaving out columns
+// hidden by the access rules.
+func (ms *MySQLServer) tableColumns(ctx context.Context, q queryer, schema, table string) ([]tableColumn, error) {
+	access := ms.accessFor(ctx)
+	colQuery := `
+		SELECT COLUMN_NAME, COLUMN_TYPE, DATA_TYPE, IS_NULLABLE, COLUMN_KEY,
+		       COLUMN_DEFAULT, EXTRA, COLUMN_COMMENT
+		FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?
+		ORDER BY ORDINAL_POSITION
+	`
+	rows, err := q.QueryContext(ctx, colQuery, schema, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []tableColumn
+	for rows.Next() {
+		var col tableColumn
+		var isNullable string
+		if err := rows.Scan(&col.name, &col.columnType, &col.dataType, &isNullable, &col.key,
+			&col.defaultValue, &col.extra, &col.comment); err != nil {
+			return nil, fmt.Errorf("failed to scan column: %w", err)
+		}
+		if !access.columnAllowed(schema, table, col.name) {
+			continue
+		}
+		col.nullable = isNullable == "YES"
+		columns = append(columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	return columns, nil
+}
+
+// quoteIdentifier quotes a schema, table or column name for MySQL.
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// columnNames returns the names of the result columns.
+func columnNames(columnTypes []*sql.ColumnType) []string {
+	names := make([]string, len(columnTypes))
+	for i, ct := range columnTypes {
+		names[i] = ct.Name()
+	}
+	return names
+}
+
+// rowObjects returns the rows as column name to value maps.
+func rowObjects(columns []string, values [][]interface{}) []map[string]interface{} {
+	results := make([]map[string]interface{}, 0, len(values))
+	for _, v := range values {
+		row := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			row[col] = v[i]
+		}
+		results = append(results, row)
+	}
+	return results
+}
+
+// getResultFormatFromArgs returns the validated format and row_format
+// arguments of a query tool, defaulting to the configured output.
+func (ms *MySQLServer) getResultFormatFromArgs(args map[string]any) (string, string, error) {
+	format, err := getFormatFromArgs(args, ms.output.Format)
+	if err != nil {
+		return "", "", err
+	}
+
+	rowFormat, _ := args["row_format"].(string)
+	if rowFormat, err = parseRowFormat(rowFormat, ms.output.RowFormat); err != nil {
+		return "", "", err
+	}
+	return format, rowFormat, nil
+}
+
+// parseRowFormat validates a row format name.
+func parseRowFormat(rowFormat, defaultRowFormat string) (string, error) {
+	switch rowFormat {
+	case "":
+		return defaultRowFormat, nil
+	case RowFormatObject, RowFormatArray:
+		return rowFormat, nil
+	}
+	return "", fmt.Errorf("invalid row_format %q (expected %s or %s)", rowFormat, RowFormatObject, RowFormatArray)
+}
+
+// getQueryLimitFromArgs returns the limit argument clamped to the maximum
+// query limit.
+func (ms *MySQLServer) getQueryLimitFromArgs(args map[string]any, defaultValue int) int {
+	limit := getIntFromArgs(args, "limit", defaultValue)
+	if limit <= 0 {
+		limit = defaultValue
+	}
+	if limit > ms.paging.MaxQueryLimit {
+		limit = ms.paging.MaxQueryLimit
+	}
+	return limit
+}
+
 func getIntFromArgs(args map[string]any, key string, defaultValue int) int {
 	if val, ok := args[key]; ok {
 		switch v := val.(type) {
@@ -454,6 +968,18 @@ func getIntFromArgs(args map[string]any, key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getBoolFromArgs(args map[string]any, key string, defaultValue bool) bool {
+	if val, ok := args[key]; ok {
+		switch v := val.(type) {
+		case bool:
+			return v
+		case string:
+			return v == "true"
+		}
+	}
+	return defaultValue
+}
+
 func jsonResult(data interface{}) (*mcp.CallToolResult, error) {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
@@ -462,12 +988,22 @@ func jsonResult(data interface{}) (*mcp.CallToolResult, error) {
 	return mcp.NewToolResultText(string(jsonData)), nil
 }
 
+// jsonErrorResult returns a tool error carrying structured details the
+// agent can act on, rather than a plain protocol error.
+func jsonErrorResult(data interface{}) (*mcp.CallToolResult, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return mcp.NewToolResultError(string(jsonData)), nil
+}
+
 func isSearchableType(dataType string) bool {
 	searchableTypes := []string{
 		"char", "varchar", "text", "tinytext", "mediumtext", "longtext",
 		"enum", "set",
 	}
-	
+
 	dataType = strings.ToLower(dataType)
 	for _, t := range searchableTypes {
 		if strings.Contains(dataType, t) {
@@ -475,4 +1011,4 @@ func isSearchableType(dataType string) bool {
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestSearchTableClampsLimit(t *testing.T) {
+	for _, tc := range []struct {
+		limit any
+		want  int
+	}{
+		{nil, DefaultQueryLimit},
+		{float64(5), 5},
+		{float64(-1), DefaultQueryLimit},
+		{float64(0), DefaultQueryLimit},
+		{float64(MaxQueryLimit + 1), MaxQueryLimit},
+	} {
+		fake := &fakeDB{tableColumns: [][]driver.Value{{"name", "varchar"}}}
+		ms := newTestServer(fake.open(), ReadOnlyOff)
+
+		var request mcp.CallToolRequest
+		request.Params.Name = "search_table"
+		args := map[string]any{"schema": "app", "table": "users", "search_term": "x"}
+		if tc.limit != nil {
+			args["limit"] = tc.limit
+		}
+		request.Params.Arguments = args
+		if _, err := ms.searchTableHandler(context.Background(), request); err != nil {
+			t.Fatalf("limit %v: %v", tc.limit, err)
+		}
+
+		want := fmt.Sprintf("LIMIT %d", tc.want)
+		var found bool
+		for _, query := range fake.received() {
+			if strings.HasPrefix(query, "SELECT `name`") {
+				found = strings.HasSuffix(query, want)
+				if !found {
+					t.Errorf("limit %v: got %q, want %s", tc.limit, query, want)
+				}
+			}
+		}
+		if !found && !t.Failed() {
+			t.Errorf("limit %v: no search query was sent: %q", tc.limit, fake.received())
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package internal
 
 import (
+	"fmt"
 	"log"
+	"net/http"
 
 	"github.com/mark3labs/mcp-go/server"
 )
@@ -12,9 +14,48 @@ func StartHTTPServer(ms *MySQLServer, addr string) error {
 	mcpServer := CreateMCPServerWithTools(ms)
 
 	// Create HTTP server with StreamableHTTPServer
-	httpServer := server.NewStreamableHTTPServer(mcpServer)
+	var handler http.Handler = server.NewStreamableHTTPServer(mcpServer)
+	if ms.userPools.mode != CredentialsShared {
+		if ms.userPools.mode == CredentialsIdentity && ms.auth == nil {
+			return fmt.Errorf("credentials mode %s needs API keys, OAuth or client certificates to identify callers", CredentialsIdentity)
+		}
+		handler = ms.userPools.middleware(handler)
+	}
+	if ms.rateLimiter != nil {
+		handler = remoteHostMiddleware(handler)
+	}
+	if ms.auth != nil {
+		handler = ms.auth.middleware(handler)
+	} else {
+		log.Printf("WARNING: no API keys or OAuth are configured, the HTTP server accepts unauthenticated requests")
+	}
 
-	log.Printf("MySQL MCP HTTP server starting on %s", addr)
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", handler)
+	if ms.auth != nil && ms.auth.oauth != nil {
+		// Clients read the metadata before they have a token
+		ms.auth.oauth.registerMetadata(mux)
+	}
 
-	return httpServer.Start(addr)
-}
\ No newline at end of file
+	srv := &http.Server{Addr: addr, Handler: mux}
+	cfg := ms.httpConfig
+	if cfg.TLSCert == "" {
+		if cfg.TLSKey != "" || cfg.ClientCA != "" {
+			return fmt.Errorf("http.tls_key and http.client_ca need http.tls_cert")
+		}
+		log.Printf("MySQL MCP HTTP server starting on %s", addr)
+		return srv.ListenAndServe()
+	}
+
+	tlsCfg, err := newServerTLS(cfg)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = tlsCfg.tlsConfig()
+	if cfg.ClientCA != "" {
+		log.Printf("MySQL MCP HTTPS server starting on %s, client certificates: %s", addr, clientAuthMode(cfg))
+	} else {
+		log.Printf("MySQL MCP HTTPS server starting on %s", addr)
+	}
+	return srv.ListenAndServeTLS("", "")
+}
@@ -0,0 +1,212 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Client certificate modes of the HTTP server
+const (
+	// ClientAuthRequire rejects TLS connections without a client
+	// certificate signed by the client CA.
+	ClientAuthRequire = "require"
+	// ClientAuthOptional verifies a client certificate when one is sent;
+	// callers without one need other credentials.
+	ClientAuthOptional = "optional"
+)
+
+// certReloadInterval is how often the certificate files are checked for
+// changes.
+const certReloadInterval = 10 * time.Second
+
+// serverTLS holds the certificate and client CAs of the HTTP server and
+// reloads them when their files change, so that renewed certificates are
+// picked up without a restart.
+type serverTLS struct {
+	cfg HTTPConfig
+
+	// mu guards the fields below
+	mu sync.Mutex
+	// config is rebuilt on every reload
+	config *tls.Config
+	// modTimes of the loaded files
+	modTimes  map[string]time.Time
+	lastCheck time.Time
+}
+
+// newServerTLS loads the certificate files of cfg.
+func newServerTLS(cfg HTTPConfig) (*serverTLS, error) {
+	if cfg.TLSKey == "" {
+		return nil, fmt.Errorf("http.tls_cert and http.tls_key must be set together")
+	}
+	s := &serverTLS{cfg: cfg}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.lastCheck = time.Now()
+	return s, nil
+}
+
+func (s *serverTLS) files() []string {
+	files := []string{s.cfg.TLSCert, s.cfg.TLSKey}
+	if s.cfg.ClientCA != "" {
+		files = append(files, s.cfg.ClientCA)
+	}
+	return files
+}
+
+// load reads the certificate files and builds the TLS configuration.
+func (s *serverTLS) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range s.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("failed to read TLS file: %w", err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.cfg.TLSCert, s.cfg.TLSKey)
+	if err != nil {
+		return fmt.Errorf("failed to load http.tls_cert and http.tls_key: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if s.cfg.ClientCA != "" {
+		pem, err := os.ReadFile(s.cfg.ClientCA)
+		if err != nil {
+			return fmt.Errorf("failed to read http.client_ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("http.client_ca %s contains no PEM certificates", s.cfg.ClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if clientAuthMode(s.cfg) == ClientAuthOptional {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	s.config = config
+	s.modTimes = modTimes
+	return nil
+}
+
+// reloadIfChanged reloads the files when one of them changed since they
+// were loaded. A failed reload, e.g. while a certificate and its key are
+// replaced one after the other, keeps the previous configuration and is
+// retried.
+func (s *serverTLS) reloadIfChanged() {
+	if time.Since(s.lastCheck) < certReloadInterval {
+		return
+	}
+	s.lastCheck = time.Now()
+
+	changed := false
+	for file, modTime := range s.modTimes {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := s.load(); err != nil {
+		log.Printf("Failed to reload TLS certificates, keeping the previous ones: %v", err)
+		return
+	}
+	log.Printf("Reloaded TLS certificates")
+}
+
+// tlsConfig returns the configuration of the HTTPS listener, which hands
+// every connection the current certificates.
+func (s *serverTLS) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.reloadIfChanged()
+			return s.config, nil
+		},
+	}
+}
+
+// clientAuthMode returns the client certificate mode of cfg.
+func clientAuthMode(cfg HTTPConfig) string {
+	if cfg.ClientAuth == "" {
+		return ClientAuthRequire
+	}
+	return cfg.ClientAuth
+}
+
+// clientCertMapping maps certificate subjects to an identity.
+type clientCertMapping struct {
+	subjects []string
+	identity *identity
+}
+
+// clientCertAuth identifies callers by their verified client certificate.
+type clientCertAuth struct {
+	// mappings in name order; without mappings a certificate's subject
+	// names the caller, who has full access
+	mappings []clientCertMapping
+}
+
+func newClientCertAuth(certs map[string]*ClientCertConfig) (*clientCertAuth, error) {
+	names := make([]string, 0, len(certs))
+	for name := range certs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	a := &clientCertAuth{}
+	for _, name := range names {
+		cfg := certs[name]
+		schemas, err := parseNamePatterns(cfg.Schemas, 1)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schemas of client certificate %s: %w", name, err)
+		}
+		a.mappings = append(a.mappings, clientCertMapping{
+			subjects: lowerAll(cfg.Subjects),
+			identity: &identity{
+				name:        name,
+				tools:       lowerAll(cfg.Tools),
+				schemas:     schemas,
+				connections: lowerAll(cfg.Connections),
+			},
+		})
+	}
+	return a, nil
+}
+
+func (a *clientCertAuth) authenticate(r *http.Request) (*identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	subject := cert.Subject.String()
+	if len(a.mappings) == 0 {
+		return &identity{name: subject}, nil
+	}
+
+	for _, m := range a.mappings {
+		if matchesAny(m.subjects, subject) || matchesAny(m.subjects, cert.Subject.CommonName) {
+			return m.identity, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: client certificate %s is not mapped to an identity", errInvalidCredentials, subject)
+}
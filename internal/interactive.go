@@ -49,21 +49,20 @@ func handleUserChoice(ctx context.Context, choice string, ms *MySQLServer, scann
 	switch choice {
 	case "1":
 		// List schemas
-		fmt.Print("Enter page number (default 1): ")
+		fmt.Print("Enter name pattern, e.g. app_% (optional): ")
 		scanner.Scan()
-		pageStr := strings.TrimSpace(scanner.Text())
-		page := 1
-		if pageStr != "" {
-			if p, err := strconv.Atoi(pageStr); err == nil {
-				page = p
-			}
-		}
+		nameLike := strings.TrimSpace(scanner.Text())
+
+		fmt.Print("Enter cursor from the previous page (optional): ")
+		scanner.Scan()
+		cursor := strings.TrimSpace(scanner.Text())
 
 		req := mcp.CallToolRequest{
 			Params: mcp.CallToolParams{
 				Name: "list_schemas",
 				Arguments: map[string]interface{}{
-					"page": page,
+					"name_like": nameLike,
+					"cursor":    cursor,
 				},
 			},
 		}
@@ -77,22 +76,21 @@ func handleUserChoice(ctx context.Context, choice string, ms *MySQLServer, scann
 		scanner.Scan()
 		schema := strings.TrimSpace(scanner.Text())
 
-		fmt.Print("Enter page number (default 1): ")
+		fmt.Print("Enter name pattern, e.g. order% (optional): ")
 		scanner.Scan()
-		pageStr := strings.TrimSpace(scanner.Text())
-		page := 1
-		if pageStr != "" {
-			if p, err := strconv.Atoi(pageStr); err == nil {
-				page = p
-			}
-		}
+		nameLike := strings.TrimSpace(scanner.Text())
+
+		fmt.Print("Enter cursor from the previous page (optional): ")
+		scanner.Scan()
+		cursor := strings.TrimSpace(scanner.Text())
 
 		req := mcp.CallToolRequest{
 			Params: mcp.CallToolParams{
 				Name: "list_tables",
 				Arguments: map[string]interface{}{
-					"schema": schema,
-					"page":   page,
+					"schema":    schema,
+					"name_like": nameLike,
+					"cursor":    cursor,
 				},
 			},
 		}
@@ -162,13 +160,27 @@ func handleUserChoice(ctx context.Context, choice string, ms *MySQLServer, scann
 			}
 		}
 
+		fmt.Print("Enter params as a JSON array (optional): ")
+		scanner.Scan()
+		paramsStr := strings.TrimSpace(scanner.Text())
+
+		arguments := map[string]interface{}{
+			"query": query,
+			"limit": limit,
+		}
+		if paramsStr != "" {
+			var params []interface{}
+			if err := json.Unmarshal([]byte(paramsStr), &params); err != nil {
+				fmt.Printf("\nError: invalid params: %v\n", err)
+				return
+			}
+			arguments["params"] = params
+		}
+
 		req := mcp.CallToolRequest{
 			Params: mcp.CallToolParams{
-				Name: "execute_query",
-				Arguments: map[string]interface{}{
-					"query": query,
-					"limit": limit,
-				},
+				Name:      "execute_query",
+				Arguments: arguments,
 			},
 		}
 
@@ -244,4 +256,4 @@ func printResult(result *mcp.CallToolResult, err error) {
 			fmt.Printf("\nUnknown content type\n")
 		}
 	}
-}
\ No newline at end of file
+}
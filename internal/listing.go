@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// tableTypes are the accepted values of the table_type filter.
+var tableTypes = []string{"BASE TABLE", "VIEW", "SYSTEM VIEW"}
+
+// listOptions are the paging and filter arguments of the listing tools.
+type listOptions struct {
+	// after is the last name of the previous page
+	after        string
+	pageSize     int
+	includeTotal bool
+	nameLike     string
+	nameRegex    string
+}
+
+func (ms *MySQLServer) getListOptionsFromArgs(args map[string]any) (*listOptions, error) {
+	opts := &listOptions{
+		pageSize:     getIntFromArgs(args, "page_size", ms.paging.DefaultPageSize),
+		includeTotal: getBoolFromArgs(args, "include_total", false),
+	}
+	if opts.pageSize <= 0 {
+		opts.pageSize = ms.paging.DefaultPageSize
+	}
+	if opts.pageSize > ms.paging.MaxPageSize {
+		opts.pageSize = ms.paging.MaxPageSize
+	}
+
+	if cursor, _ := args["cursor"].(string); cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(after) == 0 {
+			return nil, fmt.Errorf("invalid cursor %q", cursor)
+		}
+		opts.after = string(after)
+	}
+
+	opts.nameLike, _ = args["name_like"].(string)
+	opts.nameRegex, _ = args["name_regex"].(string)
+	return opts, nil
+}
+
+// filter returns the name filter conditions on column and their arguments.
+func (o *listOptions) filter(column string) ([]string, []any) {
+	var conds []string
+	var args []any
+	if o.nameLike != "" {
+		conds = append(conds, column+" LIKE ?")
+		args = append(args, o.nameLike)
+	}
+	if o.nameRegex != "" {
+		conds = append(conds, column+" REGEXP ?")
+		args = append(args, o.nameRegex)
+	}
+	return conds, args
+}
+
+// nextCursor returns the cursor continuing after name.
+func nextCursor(name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+// getTableTypeFromArgs returns the validated table_type argument.
+func getTableTypeFromArgs(args map[string]any) (string, error) {
+	tableType, _ := args["table_type"].(string)
+	if tableType == "" {
+		return "", nil
+	}
+	for _, t := range tableTypes {
+		if strings.EqualFold(tableType, t) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("invalid table_type %q (expected %s)", tableType, strings.Join(tableTypes, ", "))
+}
+
+// sqlWhere joins conditions into a WHERE clause.
+func sqlWhere(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conds, " AND ")
+}
@@ -0,0 +1,456 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/pingcap/tidb/pkg/parser/ast"
+)
+
+// Masking strategies
+const (
+	// MaskRedact replaces a value with a fixed marker.
+	MaskRedact = "redact"
+	// MaskHash replaces a value with a keyed hash, so equal values stay
+	// equal and can still be grouped and compared.
+	MaskHash = "hash"
+	// MaskPartial hides all but a few characters and keeps the shape of
+	// the value, e.g. j***@example.com or ***-***-1234.
+	MaskPartial = "partial"
+)
+
+// redactedText is the value of a redacted column.
+const redactedText = "[REDACTED]"
+
+func isMaskStrategy(s string) bool {
+	return s == MaskRedact || s == MaskHash || s == MaskPartial
+}
+
+// maskRule masks the columns matching a name pattern.
+type maskRule struct {
+	pattern  namePattern
+	strategy string
+}
+
+// maskingPolicy rewrites the values of sensitive columns in query results.
+// Columns are selected by name pattern or by a tag in the column comment.
+type maskingPolicy struct {
+	rules []maskRule
+	// commentTag masks columns whose comment contains it
+	commentTag string
+	// strategy is used for tagged columns and rules without a strategy
+	strategy string
+	// hashKey keys the hashes of MaskHash
+	hashKey []byte
+}
+
+// newMaskingPolicy parses cfg. Column patterns have the form
+// [[schema.]table.]column[:strategy]. Without a hash key a random one is
+// used, so hashes only compare within one run.
+func newMaskingPolicy(cfg MaskingConfig) (*maskingPolicy, error) {
+	p := &maskingPolicy{
+		commentTag: strings.ToLower(strings.TrimSpace(cfg.CommentTag)),
+		strategy:   strings.ToLower(strings.TrimSpace(cfg.Strategy)),
+	}
+	if p.strategy == "" {
+		p.strategy = MaskRedact
+	}
+	if !isMaskStrategy(p.strategy) {
+		return nil, fmt.Errorf("invalid %s: unknown strategy %q (expected redact, hash or partial)", configKey("masking.strategy"), cfg.Strategy)
+	}
+
+	for _, item := range cfg.Columns {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		strategy := p.strategy
+		if i := strings.LastIndex(item, ":"); i >= 0 {
+			strategy = strings.ToLower(strings.TrimSpace(item[i+1:]))
+			item = item[:i]
+			if !isMaskStrategy(strategy) {
+				return nil, fmt.Errorf("invalid %s: unknown strategy %q", configKey("masking.columns"), strategy)
+			}
+		}
+		patterns, err := parseNamePatterns([]string{item}, 3)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", configKey("masking.columns"), err)
+		}
+		for _, pattern := range patterns {
+			p.rules = append(p.rules, maskRule{pattern: pattern, strategy: strategy})
+		}
+	}
+
+	if cfg.HashKey != "" {
+		p.hashKey = []byte(cfg.HashKey)
+	} else {
+		p.hashKey = make([]byte, 32)
+		if _, err := rand.Read(p.hashKey); err != nil {
+			return nil, fmt.Errorf("failed to generate hash key: %w", err)
+		}
+	}
+	return p, nil
+}
+
+func (p *maskingPolicy) enabled() bool {
+	return len(p.rules) > 0 || p.commentTag != ""
+}
+
+// strategyFor returns the masking strategy of a column, or "" if it is not
+// masked. Name patterns take precedence over comment tags, and a tag may
+// name its strategy as tag:strategy.
+func (p *maskingPolicy) strategyFor(schema, table, column, comment string) string {
+	for _, rule := range p.rules {
+		if rule.pattern.matches(schema, table, column) {
+			return rule.strategy
+		}
+	}
+	if p.commentTag == "" {
+		return ""
+	}
+	comment = strings.ToLower(comment)
+	i := strings.Index(comment, p.commentTag)
+	if i < 0 {
+		return ""
+	}
+	if rest := comment[i+len(p.commentTag):]; strings.HasPrefix(rest, ":") {
+		name := strings.FieldsFunc(rest[1:], func(r rune) bool { return !unicode.IsLetter(r) })
+		if len(name) > 0 && isMaskStrategy(name[0]) {
+			return name[0]
+		}
+	}
+	return p.strategy
+}
+
+// apply masks an encoded value. NULL stays NULL.
+func (p *maskingPolicy) apply(strategy string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch strategy {
+	case MaskHash:
+		text, _ := cellText(v)
+		mac := hmac.New(sha256.New, p.hashKey)
+		mac.Write([]byte(text))
+		return "hash:" + hex.EncodeToString(mac.Sum(nil)[:16])
+	case MaskPartial:
+		text, _ := cellText(v)
+		return partialMask(text)
+	}
+	return redactedText
+}
+
+// partialMask keeps the first character and domain of an email address, and
+// otherwise the last characters of a value (at most 4, and at most a third
+// of it). Letters and digits are masked with *, other characters are kept.
+func partialMask(s string) string {
+	if at := strings.LastIndex(s, "@"); at > 0 {
+		local := []rune(s[:at])
+		return string(local[0]) + strings.Repeat("*", len(local)-1) + s[at:]
+	}
+
+	runes := []rune(s)
+	keep := len(runes) / 3
+	if keep > 4 {
+		keep = 4
+	}
+	for i := 0; i < len(runes)-keep; i++ {
+		if unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) {
+			runes[i] = '*'
+		}
+	}
+	return string(runes)
+}
+
+// maskPlan decides which result columns of a statement are masked.
+type maskPlan struct {
+	policy *maskingPolicy
+	// masked maps the tables of the statement with masked columns to the
+	// strategy of each masked column, keyed by lower case names
+	masked map[tableRef]map[string]string
+	// tables are all base tables of the statement, lower case
+	tables  []tableRef
+	aliases map[string][]tableRef
+	// fields are the select fields result columns are mapped to; when nil,
+	// result columns are matched by name against all tables
+	fields []*ast.SelectField
+}
+
+// maskPlanFor returns the masking plan of a statement, or nil when none of
+// its tables has masked columns. Masked columns are followed through
+// aliases, * and expressions of a plain SELECT; statements that could pass
+// them on in other ways (derived tables, CTEs, UNION, TABLE) are rejected.
+func (ms *MySQLServer) maskPlanFor(ctx context.Context, sess *dbSession, stmt ast.StmtNode) (*maskPlan, error) {
+	if !ms.masking.enabled() {
+		return nil, nil
+	}
+	switch stmt.(type) {
+	case *ast.SelectStmt, *ast.SetOprStmt:
+	default:
+		// SHOW, DESCRIBE and EXPLAIN do not return table data
+		return nil, nil
+	}
+
+	refs := newTableRefCollector()
+	stmt.Accept(refs)
+
+	var tables []tableRef
+	for _, t := range refs.tables {
+		tables = append(tables, maskTableKey(t, sess.database))
+	}
+	plan, err := ms.newMaskPlan(ctx, sess, tables)
+	if plan == nil || err != nil {
+		return nil, err
+	}
+	for alias, aliased := range refs.aliases {
+		for _, t := range aliased {
+			plan.aliases[alias] = append(plan.aliases[alias], maskTableKey(t, sess.database))
+		}
+	}
+
+	if sel, ok := stmt.(*ast.SelectStmt); ok && mappableSelect(sel) {
+		plan.fields = sel.Fields.Fields
+		return plan, nil
+	}
+
+	// Refuse anything that reads a masked column and cannot be mapped
+	for _, wildcard := range refs.wildcards {
+		for _, t := range plan.candidates(wildcard) {
+			if len(plan.masked[t]) > 0 {
+				return nil, fmt.Errorf("query rejected: %s.%s has masked columns, which can only be read by selecting from the table directly", t.schema, t.name)
+			}
+		}
+	}
+	for _, col := range refs.columns {
+		if plan.columnStrategy(col.Table.L, col.Name.L) != "" {
+			return nil, fmt.Errorf("query rejected: masked column %s can only be read by selecting from its table directly, not through a derived table, CTE, UNION or TABLE statement", col.Name.O)
+		}
+	}
+	return nil, nil
+}
+
+// tableMaskPlan returns the masking plan for rows read from a single table
+// by column name, or nil if it has no masked columns.
+func (ms *MySQLServer) tableMaskPlan(ctx context.Context, sess *dbSession, schema, table string) (*maskPlan, error) {
+	if !ms.masking.enabled() {
+		return nil, nil
+	}
+	return ms.newMaskPlan(ctx, sess, []tableRef{maskTableKey(tableRef{schema: schema, name: table}, "")})
+}
+
+// maskTableKey resolves and lower cases a table reference.
+func maskTableKey(t tableRef, defaultSchema string) tableRef {
+	if t.schema == "" {
+		t.schema = defaultSchema
+	}
+	return tableRef{schema: strings.ToLower(t.schema), name: strings.ToLower(t.name)}
+}
+
+// newMaskPlan looks up the masked columns of tables, returning nil if there
+// are none.
+func (ms *MySQLServer) newMaskPlan(ctx context.Context, sess *dbSession, tables []tableRef) (*maskPlan, error) {
+	if len(tables) == 0 {
+		return nil, nil
+	}
+
+	conds := make([]string, len(tables))
+	args := make([]any, 0, 2*len(tables))
+	for i, t := range tables {
+		conds[i] = "(TABLE_SCHEMA = ? AND TABLE_NAME = ?)"
+		args = append(args, t.schema, t.name)
+	}
+	rows, err := sess.QueryContext(ctx, `
+		SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, COLUMN_COMMENT
+		FROM information_schema.COLUMNS
+		WHERE `+strings.Join(conds, " OR "), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	defer rows.Close()
+
+	masked := make(map[tableRef]map[string]string)
+	for rows.Next() {
+		var schema, table, column, comment string
+		if err := rows.Scan(&schema, &table, &column, &comment); err != nil {
+			return nil, fmt.Errorf("failed to scan column: %w", err)
+		}
+		strategy := ms.masking.strategyFor(schema, table, column, comment)
+		if strategy == "" {
+			continue
+		}
+		key := tableRef{schema: strings.ToLower(schema), name: strings.ToLower(table)}
+		if masked[key] == nil {
+			masked[key] = make(map[string]string)
+		}
+		masked[key][strings.ToLower(column)] = strategy
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	if len(masked) == 0 {
+		return nil, nil
+	}
+
+	return &maskPlan{
+		policy:  ms.masking,
+		masked:  masked,
+		tables:  tables,
+		aliases: make(map[string][]tableRef),
+	}, nil
+}
+
+// mappableSelect reports whether every result column of sel can be traced
+// to the fields of sel and the base tables it reads from.
+func mappableSelect(sel *ast.SelectStmt) bool {
+	if sel.Kind != ast.SelectStmtKindSelect || sel.With != nil || sel.Fields == nil {
+		return false
+	}
+	wildcards := 0
+	for _, field := range sel.Fields.Fields {
+		if field.WildCard != nil {
+			wildcards++
+		}
+	}
+	if wildcards > 1 {
+		return false
+	}
+	if sel.From == nil {
+		return true
+	}
+
+	mappable := true
+	var walk func(node ast.ResultSetNode)
+	walk = func(node ast.ResultSetNode) {
+		switch n := node.(type) {
+		case *ast.Join:
+			if n.Left != nil {
+				walk(n.Left)
+			}
+			if n.Right != nil {
+				walk(n.Right)
+			}
+		case *ast.TableSource:
+			if _, ok := n.Source.(*ast.TableName); !ok {
+				mappable = false
+			}
+		default:
+			mappable = false
+		}
+	}
+	walk(sel.From.TableRefs)
+	return mappable
+}
+
+// candidates returns the tables a qualifier may refer to, all tables when
+// it is empty or unknown.
+func (p *maskPlan) candidates(qualifier string) []tableRef {
+	if qualifier != "" {
+		if tables := p.aliases[strings.ToLower(qualifier)]; len(tables) > 0 {
+			return tables
+		}
+	}
+	return p.tables
+}
+
+// columnStrategy returns the strategy of a column reference, masking it if
+// any table it may belong to masks a column of that name.
+func (p *maskPlan) columnStrategy(qualifier, column string) string {
+	column = strings.ToLower(column)
+	for _, t := range p.candidates(qualifier) {
+		if strategy := p.masked[t][column]; strategy != "" {
+			return strategy
+		}
+	}
+	return ""
+}
+
+// strategies returns the masking strategy of each result column, "" for
+// columns that are not masked, or nil when nothing is masked.
+func (p *maskPlan) strategies(names []string) []string {
+	if p == nil {
+		return nil
+	}
+
+	strategies := make([]string, len(names))
+	if p.fields == nil {
+		for i, name := range names {
+			strategies[i] = p.columnStrategy("", name)
+		}
+		return strategies
+	}
+
+	wildcardWidth := len(names) - len(p.fields) + 1
+	i := 0
+	for _, field := range p.fields {
+		if field.WildCard != nil {
+			for n := 0; n < wildcardWidth && i < len(names); n++ {
+				strategies[i] = p.columnStrategy(field.WildCard.Table.L, names[i])
+				i++
+			}
+			continue
+		}
+		if i < len(names) {
+			strategies[i] = p.exprStrategy(field.Expr)
+			i++
+		}
+	}
+	for ; i < len(names); i++ {
+		// Never leave a column unaccounted for
+		strategies[i] = MaskRedact
+	}
+	return strategies
+}
+
+// exprStrategy masks an expression with the strategy of the first masked
+// column it reads. COUNT() reveals nothing about values and is not masked.
+func (p *maskPlan) exprStrategy(expr ast.ExprNode) string {
+	if agg, ok := expr.(*ast.AggregateFuncExpr); ok && strings.EqualFold(agg.F, ast.AggFuncCount) {
+		return ""
+	}
+	refs := newTableRefCollector()
+	expr.Accept(refs)
+	for _, col := range refs.columns {
+		if strategy := p.columnStrategy(col.Table.L, col.Name.L); strategy != "" {
+			return strategy
+		}
+	}
+	// A * inside a subquery reads whole rows
+	for _, wildcard := range refs.wildcards {
+		for _, t := range p.candidates(wildcard) {
+			if len(p.masked[t]) > 0 {
+				return MaskRedact
+			}
+		}
+	}
+	return ""
+}
+
+// masksAny reports whether any of the keyset columns is masked.
+func (p *maskPlan) masksAny(columns []keysetColumn) bool {
+	if p == nil {
+		return false
+	}
+	for _, col := range columns {
+		if p.columnStrategy(col.qualifier, col.name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// maskedColumns maps the names of masked columns to their strategy.
+func maskedColumns(names, strategies []string) map[string]string {
+	masked := make(map[string]string)
+	for i, strategy := range strategies {
+		if strategy != "" && i < len(names) {
+			masked[names[i]] = strategy
+		}
+	}
+	return masked
+}
@@ -0,0 +1,612 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwtLeeway tolerates clock skew with the authorization server
+	jwtLeeway = time.Minute
+	// jwksCacheTTL is how long fetched signing keys are used before they
+	// are fetched again
+	jwksCacheTTL = time.Hour
+	// jwksMinRefresh limits refetching the keys for tokens with an unknown
+	// key ID
+	jwksMinRefresh = time.Minute
+	// maxJWKSBytes limits the size of a fetched key set
+	maxJWKSBytes = 1 << 20
+
+	// wellKnownResourcePath is where the protected resource metadata of
+	// RFC 9728 is served
+	wellKnownResourcePath = "/.well-known/oauth-protected-resource"
+)
+
+// jwtHashes maps the accepted signature algorithms to their hash. EdDSA
+// signs the message itself. Unsigned and HMAC tokens are never accepted.
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// jwtAuth accepts JWT access tokens issued for the resource by an OAuth
+// authorization server, sent as bearer tokens.
+type jwtAuth struct {
+	resource             string
+	authorizationServers []string
+	issuer               string
+	audience             string
+	identityClaim        string
+	keys                 *jwks
+	// scopes maps token scopes to what they grant, as identities without
+	// a name
+	scopes map[string]*identity
+}
+
+// newJWTAuth returns the authenticator of cfg. A key file is read at once so
+// that mistakes show at startup; a key URL is fetched on first use.
+func newJWTAuth(cfg OAuthConfig) (*jwtAuth, error) {
+	a := &jwtAuth{
+		resource:             cfg.Resource,
+		authorizationServers: cfg.AuthorizationServers,
+		issuer:               cfg.Issuer,
+		audience:             cfg.Audience,
+		identityClaim:        cfg.IdentityClaim,
+		keys:                 &jwks{url: cfg.JWKSURL, file: cfg.JWKSFile, client: &http.Client{Timeout: 10 * time.Second}},
+		scopes:               make(map[string]*identity, len(cfg.Scopes)),
+	}
+	if a.audience == "" {
+		a.audience = a.resource
+	}
+	if a.identityClaim == "" {
+		a.identityClaim = "sub"
+	}
+	if len(a.authorizationServers) == 0 {
+		a.authorizationServers = []string{a.issuer}
+	}
+
+	for scope, grant := range cfg.Scopes {
+		schemas, err := parseNamePatterns(grant.Schemas, 1)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schemas of scope %s: %w", scope, err)
+		}
+		a.scopes[scope] = &identity{
+			tools:       lowerAll(grant.Tools),
+			schemas:     schemas,
+			connections: lowerAll(grant.Connections),
+		}
+	}
+
+	if a.keys.file != "" {
+		if err := a.keys.load(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *jwtAuth) authenticate(r *http.Request) (*identity, error) {
+	token := bearerToken(r)
+	if strings.Count(token, ".") != 2 {
+		// Not a JWT
+		return nil, nil
+	}
+
+	claims, err := a.verify(token, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidCredentials, err)
+	}
+	name, _ := claims[a.identityClaim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("%w: token has no %s claim", errInvalidCredentials, a.identityClaim)
+	}
+	return a.grant(name, tokenScopes(claims))
+}
+
+// verify checks the signature and the registered claims of token and
+// returns its claims.
+func (a *jwtAuth) verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg  string   `json:"alg"`
+		Kid  string   `json:"kid"`
+		Crit []string `json:"crit"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %w", err)
+	}
+	if len(header.Crit) > 0 {
+		return nil, fmt.Errorf("unsupported critical header parameters %s", strings.Join(header.Crit, ", "))
+	}
+	if _, ok := jwtHashes[header.Alg]; !ok && header.Alg != "EdDSA" {
+		return nil, fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature encoding")
+	}
+
+	key, err := a.keys.lookup(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if key.alg != "" && key.alg != header.Alg {
+		return nil, fmt.Errorf("key %q is for %s, not %s", key.kid, key.alg, header.Alg)
+	}
+	if err := verifyJWTSignature(header.Alg, key.key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %w", err)
+	}
+
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return nil, fmt.Errorf("token has no exp claim")
+	}
+	if now.After(exp.Add(jwtLeeway)) {
+		return nil, fmt.Errorf("token expired at %s", exp.UTC().Format(time.RFC3339))
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(jwtLeeway).Before(nbf) {
+		return nil, fmt.Errorf("token is not valid before %s", nbf.UTC().Format(time.RFC3339))
+	}
+	if iss, _ := claims["iss"].(string); a.issuer != "" && iss != a.issuer {
+		return nil, fmt.Errorf("token issued by %q, expected %q", iss, a.issuer)
+	}
+	audienceOK := false
+	for _, aud := range stringsClaim(claims["aud"]) {
+		if aud == a.audience {
+			audienceOK = true
+		}
+	}
+	if !audienceOK {
+		return nil, fmt.Errorf("token audience is not %s", a.audience)
+	}
+	return claims, nil
+}
+
+// grant returns the identity of a token with scopes. Each scope grants its
+// tools, schemas and connections; without configured scopes every token
+// has full access.
+func (a *jwtAuth) grant(name string, scopes []string) (*identity, error) {
+	id := &identity{name: name}
+	if len(a.scopes) == 0 {
+		return id, nil
+	}
+
+	var granted []*identity
+	for _, scope := range scopes {
+		if g, ok := a.scopes[scope]; ok {
+			granted = append(granted, g)
+		}
+	}
+	if len(granted) == 0 {
+		return nil, fmt.Errorf("%w: token of %s has none of the scopes %s", errInsufficientScope, name, strings.Join(a.scopeNames(), ", "))
+	}
+
+	// A scope without patterns allows all, whatever the other scopes grant
+	allTools, allSchemas, allConnections := false, false, false
+	for _, g := range granted {
+		allTools = allTools || len(g.tools) == 0
+		allSchemas = allSchemas || len(g.schemas) == 0
+		allConnections = allConnections || len(g.connections) == 0
+		id.tools = append(id.tools, g.tools...)
+		id.schemas = append(id.schemas, g.schemas...)
+		id.connections = append(id.connections, g.connections...)
+	}
+	if allTools {
+		id.tools = nil
+	}
+	if allSchemas {
+		id.schemas = nil
+	}
+	if allConnections {
+		id.connections = nil
+	}
+	return id, nil
+}
+
+func (a *jwtAuth) scopeNames() []string {
+	names := make([]string, 0, len(a.scopes))
+	for name := range a.scopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// metadataPath returns the path of the protected resource metadata of the
+// resource, the well-known prefix followed by the resource path.
+func (a *jwtAuth) metadataPath() string {
+	u, err := url.Parse(a.resource)
+	if err != nil {
+		return wellKnownResourcePath
+	}
+	return wellKnownResourcePath + strings.TrimSuffix(u.Path, "/")
+}
+
+// metadataURL returns the URL of the protected resource metadata, sent to
+// clients in WWW-Authenticate.
+func (a *jwtAuth) metadataURL() string {
+	u, err := url.Parse(a.resource)
+	if err != nil {
+		return a.resource
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: a.metadataPath()}).String()
+}
+
+// registerMetadata serves the protected resource metadata, which clients
+// read before authenticating, at its path and at the bare well-known path.
+func (a *jwtAuth) registerMetadata(mux *http.ServeMux) {
+	metadata := map[string]interface{}{
+		"resource":                 a.resource,
+		"authorization_servers":    a.authorizationServers,
+		"bearer_methods_supported": []string{"header"},
+		"resource_name":            "MySQL MCP Server",
+	}
+	if len(a.scopes) > 0 {
+		metadata["scopes_supported"] = a.scopeNames()
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(metadata)
+	})
+	mux.Handle(wellKnownResourcePath, handler)
+	if path := a.metadataPath(); path != wellKnownResourcePath {
+		mux.Handle(path, handler)
+	}
+}
+
+// decodeJWTPart decodes a base64url JSON part of a token into v.
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("invalid base64url encoding")
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// verifyJWTSignature checks the signature of signed with key using alg.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	invalid := errors.New("invalid token signature")
+
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not match algorithm %s", alg)
+		}
+		if !ed25519.Verify(pub, signed, signature) {
+			return invalid
+		}
+		return nil
+	}
+
+	hash, ok := jwtHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+		case "PS":
+			err = rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		default:
+			return fmt.Errorf("key does not match algorithm %s", alg)
+		}
+		if err != nil {
+			return invalid
+		}
+		return nil
+
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" || ecCurves[alg] != pub.Curve {
+			return fmt.Errorf("key does not match algorithm %s", alg)
+		}
+		// The signature is r and s, each the size of the curve order
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return invalid
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return invalid
+		}
+		return nil
+	}
+	return fmt.Errorf("key does not match algorithm %s", alg)
+}
+
+// ecCurves maps the ECDSA algorithms to their curve.
+var ecCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// numericDate reads a NumericDate claim, seconds since the epoch.
+func numericDate(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true
+}
+
+// stringsClaim reads a claim that is a string or an array of strings.
+func stringsClaim(v interface{}) []string {
+	switch val := v.(type) {
+	case string:
+		return []string{val}
+	case []interface{}:
+		var items []string
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		}
+		return items
+	}
+	return nil
+}
+
+// tokenScopes returns the scopes of a token, from the space separated scope
+// claim or the scp claim some servers use instead.
+func tokenScopes(claims map[string]interface{}) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	var scopes []string
+	for _, s := range stringsClaim(claims["scp"]) {
+		scopes = append(scopes, strings.Fields(s)...)
+	}
+	return scopes
+}
+
+// jwk is a signing key of a JWK set.
+type jwk struct {
+	kid string
+	// alg is the algorithm the key is restricted to, if any
+	alg string
+	key crypto.PublicKey
+}
+
+// jwks holds the signing keys of the authorization server, read from a
+// file or fetched from a URL and refreshed when they get old or a token
+// names an unknown key.
+type jwks struct {
+	url    string
+	file   string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      []jwk
+	loaded    time.Time
+	lastFetch time.Time
+}
+
+// lookup returns the key with ID kid, or the only key when kid is empty.
+func (s *jwks) lookup(kid string) (*jwk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := s.find(kid)
+	if (key == nil || time.Since(s.loaded) > jwksCacheTTL) && time.Since(s.lastFetch) >= jwksMinRefresh {
+		if err := s.loadLocked(); err != nil {
+			if s.keys == nil {
+				return nil, err
+			}
+			// Keep using the keys we have
+			log.Printf("Failed to refresh JWKS: %v", err)
+		}
+		key = s.find(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (s *jwks) find(kid string) *jwk {
+	if kid == "" && len(s.keys) == 1 {
+		return &s.keys[0]
+	}
+	for i := range s.keys {
+		if s.keys[i].kid == kid {
+			return &s.keys[i]
+		}
+	}
+	return nil
+}
+
+func (s *jwks) load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadLocked()
+}
+
+func (s *jwks) loadLocked() error {
+	s.lastFetch = time.Now()
+
+	var data []byte
+	var err error
+	if s.file != "" {
+		data, err = os.ReadFile(s.file)
+		if err != nil {
+			return fmt.Errorf("failed to read JWKS: %w", err)
+		}
+	} else {
+		data, err = s.fetch()
+		if err != nil {
+			return fmt.Errorf("failed to fetch JWKS from %s: %w", s.url, err)
+		}
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("invalid JWKS: %w", err)
+	}
+	s.keys = keys
+	s.loaded = time.Now()
+	return nil
+}
+
+func (s *jwks) fetch() ([]byte, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSBytes))
+}
+
+// parseJWKS parses the RSA, EC and Ed25519 signing keys of a JWK set.
+// Other keys, such as encryption or symmetric keys, are skipped.
+func parseJWKS(data []byte) ([]jwk, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []jwk
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = rsaKey(k.N, k.E)
+		case "EC":
+			key, err = ecKey(k.Crv, k.X, k.Y)
+		case "OKP":
+			key, err = ed25519Key(k.Crv, k.X)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %d (%q): %w", i+1, k.Kid, err)
+		}
+		keys = append(keys, jwk{kid: k.Kid, alg: k.Alg, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RSA, EC or Ed25519 signing keys")
+	}
+	return keys, nil
+}
+
+func rsaKey(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, fmt.Errorf("invalid n")
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil || len(eb) == 0 || len(eb) > 4 {
+		return nil, fmt.Errorf("invalid e")
+	}
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(new(big.Int).SetBytes(eb).Int64())}
+	if key.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("RSA key of %d bits is too short", key.N.BitLen())
+	}
+	return key, nil
+}
+
+func ecKey(crv, x, y string) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+	xb, errX := base64.RawURLEncoding.DecodeString(x)
+	yb, errY := base64.RawURLEncoding.DecodeString(y)
+	if errX != nil || errY != nil {
+		return nil, fmt.Errorf("invalid x or y")
+	}
+	key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}
+	// Rejects points that are not on the curve
+	if _, err := key.ECDH(); err != nil {
+		return nil, fmt.Errorf("invalid %s point", crv)
+	}
+	return key, nil
+}
+
+func ed25519Key(crv, x string) (ed25519.PublicKey, error) {
+	if crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+	xb, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil || len(xb) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid x")
+	}
+	return ed25519.PublicKey(xb), nil
+}
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	driver "github.com/pingcap/tidb/pkg/parser/test_driver"
+)
+
+var decimalPattern = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// datetimeLayouts are the accepted formats for datetime parameters.
+var datetimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parseQueryParams converts the params argument of execute_query into
+// values for database/sql. Each element is either a plain JSON value or an
+// object of the form {"type": "...", "value": ...} where type is one of
+// string, int, decimal, datetime, null or bytes (base64 encoded).
+func parseQueryParams(raw any) ([]any, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	list, ok := raw.([]any)
+	if !ok {
+		return nil, fmt.Errorf("params must be an array")
+	}
+
+	params := make([]any, len(list))
+	for i, p := range list {
+		value, err := convertQueryParam(p)
+		if err != nil {
+			return nil, fmt.Errorf("params[%d]: %w", i, err)
+		}
+		params[i] = value
+	}
+	return params, nil
+}
+
+func convertQueryParam(p any) (any, error) {
+	switch v := p.(type) {
+	case nil, string, bool:
+		return v, nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v), nil
+		}
+		return v, nil
+	case map[string]any:
+		typ, _ := v["type"].(string)
+		return convertTypedParam(typ, v["value"])
+	}
+	return nil, fmt.Errorf("unsupported value %v", p)
+}
+
+func convertTypedParam(typ string, value any) (any, error) {
+	if typ == "null" || value == nil {
+		return nil, nil
+	}
+
+	switch typ {
+	case "string":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+	case "int":
+		switch v := value.(type) {
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+				return int64(v), nil
+			}
+		case string:
+			// Large integers are passed as strings to avoid float rounding
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return n, nil
+			}
+			if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+				return n, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid int value %v", value)
+	case "decimal":
+		// Decimals are sent as strings so MySQL converts them exactly
+		switch v := value.(type) {
+		case string:
+			if decimalPattern.MatchString(v) {
+				return v, nil
+			}
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+		return nil, fmt.Errorf("invalid decimal value %v", value)
+	case "datetime":
+		if s, ok := value.(string); ok {
+			for _, layout := range datetimeLayouts {
+				if t, err := time.Parse(layout, s); err == nil {
+					return t, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("invalid datetime value %v (expected RFC 3339 or YYYY-MM-DD[ HH:MM:SS])", value)
+	case "bytes":
+		if s, ok := value.(string); ok {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 value: %w", err)
+			}
+			return b, nil
+		}
+		return nil, fmt.Errorf("bytes value must be a base64 string")
+	default:
+		return nil, fmt.Errorf("unknown type %q (expected string, int, decimal, datetime, null or bytes)", typ)
+	}
+
+	return nil, fmt.Errorf("invalid %s value %v", typ, value)
+}
+
+// paramMarkers returns the ? placeholders of stmt in the order they appear
+// in the query text, which is the order their values are bound in.
+func paramMarkers(stmt ast.StmtNode) []*driver.ParamMarkerExpr {
+	collector := &paramMarkerCollector{}
+	stmt.Accept(collector)
+	sort.Slice(collector.markers, func(i, j int) bool {
+		return collector.markers[i].Offset < collector.markers[j].Offset
+	})
+	return collector.markers
+}
+
+type paramMarkerCollector struct {
+	markers []*driver.ParamMarkerExpr
+}
+
+func (c *paramMarkerCollector) Enter(n ast.Node) (ast.Node, bool) {
+	if marker, ok := n.(*driver.ParamMarkerExpr); ok {
+		c.markers = append(c.markers, marker)
+	}
+	return n, false
+}
+
+func (c *paramMarkerCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// paramInt returns a bound parameter as an integer.
+func paramInt(value any) (uint64, bool) {
+	switch v := value.(type) {
+	case int64:
+		if v >= 0 {
+			return uint64(v), true
+		}
+	case uint64:
+		return v, true
+	case string:
+		n, err := strconv.ParseUint(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,349 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// DefaultRateLimitWindow is the period over which rows and bytes are
+// counted.
+const DefaultRateLimitWindow = time.Minute
+
+// rateStatePruneInterval is how often the state of idle callers is dropped.
+const rateStatePruneInterval = time.Minute
+
+// rateKey identifies the counters of one limit scope: all callers or one
+// identity, over all tools or one tool.
+type rateKey struct {
+	perIdentity bool
+	identity    string
+	tool        string
+}
+
+// rateState holds the counters of one scope.
+type rateState struct {
+	// tokens of the calls per minute bucket, refilled continuously
+	tokens   float64
+	refilled time.Time
+
+	inFlight int
+
+	windowStart time.Time
+	rows        int64
+	bytes       int64
+
+	lastUsed time.Time
+}
+
+// rateRule is a configured limit scope that applies to a tool call.
+type rateRule struct {
+	key    rateKey
+	limits RateLimitConfig
+}
+
+// rateLimiter enforces the rate limits of tool calls.
+type rateLimiter struct {
+	window      time.Duration
+	global      RateLimitConfig
+	perIdentity RateLimitConfig
+	tools       map[string]*ToolRateLimits
+
+	mu        sync.Mutex
+	states    map[rateKey]*rateState
+	lastPrune time.Time
+}
+
+// newRateLimiter returns the limiter of cfg, or nil when no limit is set.
+func newRateLimiter(cfg RateLimitsConfig) *rateLimiter {
+	enabled := cfg.Global != (RateLimitConfig{}) || cfg.PerIdentity != (RateLimitConfig{})
+	for _, limits := range cfg.Tools {
+		enabled = enabled || limits.Global != (RateLimitConfig{}) || limits.PerIdentity != (RateLimitConfig{})
+	}
+	if !enabled {
+		return nil
+	}
+	return &rateLimiter{
+		window:      cfg.Window,
+		global:      cfg.Global,
+		perIdentity: cfg.PerIdentity,
+		tools:       cfg.Tools,
+		states:      make(map[rateKey]*rateState),
+	}
+}
+
+// checkRateLimitTools rejects limits under rate_limits.tools for tools the
+// server does not have.
+func checkRateLimitTools(cfg RateLimitsConfig, tools []server.ServerTool) error {
+	registered := make(map[string]bool, len(tools))
+	names := make([]string, len(tools))
+	for i, tool := range tools {
+		registered[tool.Tool.Name] = true
+		names[i] = tool.Tool.Name
+	}
+	var unknown []string
+	for name := range cfg.Tools {
+		if !registered[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("invalid config: %s: unknown tool (expected one of %s)", configKey("rate_limits.tools."+unknown[0]), strings.Join(names, ", "))
+}
+
+// rateLimitedError reports a tool call refused by a rate limit.
+type rateLimitedError struct {
+	limit      string
+	value      int64
+	scope      string
+	tool       string
+	retryAfter int
+}
+
+func (e *rateLimitedError) Error() string {
+	if e.retryAfter == 1 {
+		return "rate limited, retry after 1 second"
+	}
+	return fmt.Sprintf("rate limited, retry after %d seconds", e.retryAfter)
+}
+
+// result returns the rejection as a tool error the agent can act on.
+func (e *rateLimitedError) result() (*mcp.CallToolResult, error) {
+	details := map[string]interface{}{
+		"error":               e.Error(),
+		"rate_limited":        true,
+		"retry_after_seconds": e.retryAfter,
+		"limit":               e.limit,
+		"limit_value":         e.value,
+		"scope":               e.scope,
+	}
+	if e.tool != "" {
+		details["tool"] = e.tool
+	}
+	return jsonErrorResult(details)
+}
+
+// rules returns the limit scopes that apply to a call of tool by identity.
+func (rl *rateLimiter) rules(tool, identity string) []rateRule {
+	all := []rateRule{
+		{key: rateKey{}, limits: rl.global},
+		{key: rateKey{perIdentity: true, identity: identity}, limits: rl.perIdentity},
+	}
+	if t, ok := rl.tools[tool]; ok {
+		all = append(all,
+			rateRule{key: rateKey{tool: tool}, limits: t.Global},
+			rateRule{key: rateKey{perIdentity: true, identity: identity, tool: tool}, limits: t.PerIdentity},
+		)
+	}
+
+	var rules []rateRule
+	for _, rule := range all {
+		if rule.limits != (RateLimitConfig{}) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// state returns the counters of key, with the calls bucket refilled and an
+// expired rows and bytes window reset.
+func (rl *rateLimiter) state(key rateKey, limits RateLimitConfig, now time.Time) *rateState {
+	st, ok := rl.states[key]
+	if !ok {
+		st = &rateState{tokens: float64(limits.CallsPerMinute), refilled: now, windowStart: now}
+		rl.states[key] = st
+	}
+	if limits.CallsPerMinute > 0 {
+		rate := float64(limits.CallsPerMinute) / time.Minute.Seconds()
+		st.tokens = math.Min(float64(limits.CallsPerMinute), st.tokens+now.Sub(st.refilled).Seconds()*rate)
+	}
+	st.refilled = now
+	if now.Sub(st.windowStart) >= rl.window {
+		st.windowStart, st.rows, st.bytes = now, 0, 0
+	}
+	st.lastUsed = now
+	return st
+}
+
+// acquire admits a call of tool by identity, or returns the limit it
+// exceeds. An admitted call must be released with its rows and bytes.
+func (rl *rateLimiter) acquire(tool, identity string) ([]rateRule, *rateLimitedError) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.prune(now)
+
+	rules := rl.rules(tool, identity)
+	// Check every scope before counting the call in any of them
+	var limited *rateLimitedError
+	for _, rule := range rules {
+		st := rl.state(rule.key, rule.limits, now)
+		if err := rl.check(rule, st, now); err != nil && (limited == nil || err.retryAfter > limited.retryAfter) {
+			limited = err
+		}
+	}
+	if limited != nil {
+		return nil, limited
+	}
+
+	for _, rule := range rules {
+		st := rl.states[rule.key]
+		if rule.limits.CallsPerMinute > 0 {
+			st.tokens--
+		}
+		st.inFlight++
+	}
+	return rules, nil
+}
+
+// check returns the limit of rule that st exceeds, if any.
+func (rl *rateLimiter) check(rule rateRule, st *rateState, now time.Time) *rateLimitedError {
+	l := rule.limits
+	limited := func(limit string, value int64, retryAfter time.Duration) *rateLimitedError {
+		scope := "global"
+		if rule.key.perIdentity {
+			scope = "identity"
+		}
+		return &rateLimitedError{
+			limit:      limit,
+			value:      value,
+			scope:      scope,
+			tool:       rule.key.tool,
+			retryAfter: int(math.Max(1, math.Ceil(retryAfter.Seconds()))),
+		}
+	}
+	windowLeft := st.windowStart.Add(rl.window).Sub(now)
+
+	switch {
+	case l.CallsPerMinute > 0 && st.tokens < 1:
+		rate := float64(l.CallsPerMinute) / time.Minute.Seconds()
+		return limited("calls_per_minute", int64(l.CallsPerMinute), time.Duration((1-st.tokens)/rate*float64(time.Second)))
+	case l.Concurrent > 0 && st.inFlight >= l.Concurrent:
+		// Calls in flight usually finish within seconds
+		return limited("concurrent", int64(l.Concurrent), time.Second)
+	case l.Rows > 0 && st.rows >= l.Rows:
+		return limited("rows", l.Rows, windowLeft)
+	case l.Bytes > 0 && st.bytes >= l.Bytes:
+		return limited("bytes", l.Bytes, windowLeft)
+	}
+	return nil
+}
+
+// release ends an admitted call and counts the rows and bytes it returned.
+func (rl *rateLimiter) release(rules []rateRule, rows, bytes int64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	for _, rule := range rules {
+		st := rl.state(rule.key, rule.limits, now)
+		st.inFlight--
+		st.rows += rows
+		st.bytes += bytes
+	}
+}
+
+// prune drops the counters of scopes without calls in flight that have been
+// idle long enough for all of them to be back at their initial values.
+func (rl *rateLimiter) prune(now time.Time) {
+	if now.Sub(rl.lastPrune) < rateStatePruneInterval {
+		return
+	}
+	rl.lastPrune = now
+	idle := rl.window
+	if idle < time.Minute {
+		idle = time.Minute
+	}
+	for key, st := range rl.states {
+		if st.inFlight == 0 && now.Sub(st.lastUsed) > idle {
+			delete(rl.states, key)
+		}
+	}
+}
+
+// callUsage counts the rows a tool call returns.
+type callUsage struct {
+	mu   sync.Mutex
+	rows int64
+}
+
+type callUsageKey struct{}
+
+// usageRows counts rows returned by a tool call against the rows limits.
+func usageRows(ctx context.Context, n int) {
+	if u, ok := ctx.Value(callUsageKey{}).(*callUsage); ok {
+		u.mu.Lock()
+		u.rows += int64(n)
+		u.mu.Unlock()
+	}
+}
+
+type remoteHostKey struct{}
+
+// remoteHostMiddleware puts the client address of each HTTP request into
+// its context, so that unauthenticated callers can be told apart.
+func remoteHostMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), remoteHostKey{}, host)))
+	})
+}
+
+// rateIdentity returns the caller the per_identity limits count a tool call
+// against: the authenticated identity or, for a caller without
+// credentials, its client address. Stdio calls all share one caller.
+func rateIdentity(ctx context.Context) string {
+	if name := identityName(ctx); name != "" {
+		return "identity:" + name
+	}
+	host, _ := ctx.Value(remoteHostKey{}).(string)
+	return "address:" + host
+}
+
+// rateLimitMiddleware refuses tool calls over a rate limit with a tool error
+// telling the agent when to retry, and counts the rows and bytes of the
+// others.
+func (ms *MySQLServer) rateLimitMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		rules, limited := ms.rateLimiter.acquire(request.Params.Name, rateIdentity(ctx))
+		if limited != nil {
+			return limited.result()
+		}
+
+		usage := &callUsage{}
+		var result *mcp.CallToolResult
+		var err error
+		defer func() {
+			var bytes int64
+			if result != nil {
+				for _, content := range result.Content {
+					if t, ok := mcp.AsTextContent(content); ok {
+						bytes += int64(len(t.Text))
+					}
+				}
+			}
+			usage.mu.Lock()
+			defer usage.mu.Unlock()
+			ms.rateLimiter.release(rules, usage.rows, bytes)
+		}()
+
+		result, err = next(context.WithValue(ctx, callUsageKey{}, usage), request)
+		return result, err
+	}
+}
@@ -1,11 +1,9 @@
 package internal
 
 import (
-	"database/sql"
-	"fmt"
-	"os"
+	"context"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
@@ -13,72 +11,214 @@ import (
 const (
 	DefaultPageSize = 20
 	MaxPageSize     = 100
+
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+
+	DefaultQueryTimeout = 30 * time.Second
 )
 
 type MySQLServer struct {
-	db *sql.DB
+	// Named databases, the primary one is used when a call names none
+	connections map[string]*dbConnection
+	primary     string
+
+	readOnlyMode string
+	queryTimeout time.Duration
+	calls        *toolCalls
+
+	// Cost guard thresholds for execute_query, zero disables a check
+	maxQueryCost         float64
+	maxRowsExamined      int64
+	fullScanRowThreshold int64
+
+	// Size limits for tool results
+	budget responseBudget
+
+	// Page sizes and row limits
+	paging PagingConfig
+
+	// Result format used when a call names none
+	output OutputConfig
+
+	// Open execute_query cursors
+	cursors *cursorStore
+
+	// Schemas, tables and columns hidden from all tools
+	access *accessPolicy
+
+	// Columns whose values are masked in results
+	masking *maskingPolicy
+
+	// Audit log of tool calls, nil when disabled
+	audit *auditLog
+
+	// Authentication of the HTTP server, nil when disabled
+	auth *httpAuth
+
+	// HTTPS settings of the HTTP server
+	httpConfig HTTPConfig
+
+	// Connection pools of the MySQL users HTTP tool calls run as
+	userPools *userPools
+
+	// Limits on tool calls, nil when disabled
+	rateLimiter *rateLimiter
 }
 
-func NewMySQLServer() (*MySQLServer, error) {
-	host := os.Getenv("MYSQL_HOST")
-	if host == "" {
-		host = "localhost"
+// NewMySQLServer connects to the databases of the profiles of cfg, with
+// the selected profile as the primary connection.
+func NewMySQLServer(cfg *Config) (*MySQLServer, error) {
+	access, err := newAccessPolicy(cfg.Access)
+	if err != nil {
+		return nil, err
 	}
 
-	port := os.Getenv("MYSQL_PORT")
-	if port == "" {
-		port = "3306"
+	masking, err := newMaskingPolicy(cfg.Masking)
+	if err != nil {
+		return nil, err
 	}
 
-	user := os.Getenv("MYSQL_USER")
-	if user == "" {
-		user = "root"
+	audit, err := newAuditLog(cfg.Audit)
+	if err != nil {
+		return nil, err
 	}
 
-	password := os.Getenv("MYSQL_PASSWORD")
-	database := os.Getenv("MYSQL_DATABASE")
-
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", user, password, host, port, database)
-
-	db, err := sql.Open("mysql", dsn)
+	connections, primary, err := openConnections(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database connection: %w", err)
+		return nil, err
 	}
 
-	// Test the connection
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	auth, err := newHTTPAuth(cfg.Auth, cfg.HTTP, primary)
+	if err != nil {
+		return nil, err
 	}
 
-	return &MySQLServer{db: db}, nil
+	limits := cfg.Limits
+	ms := &MySQLServer{
+		connections:          connections,
+		primary:              primary,
+		readOnlyMode:         limits.ReadOnlyMode,
+		queryTimeout:         limits.QueryTimeout,
+		calls:                newToolCalls(),
+		maxQueryCost:         limits.MaxQueryCost,
+		maxRowsExamined:      limits.MaxRowsExamined,
+		fullScanRowThreshold: limits.FullScanRowThreshold,
+		budget:               responseBudget{maxResponseBytes: limits.MaxResponseBytes, maxCellBytes: limits.MaxCellBytes},
+		paging:               cfg.Paging,
+		output:               cfg.Output,
+		cursors:              newCursorStore(limits.CursorTTL),
+		access:               access,
+		masking:              masking,
+		audit:                audit,
+		auth:                 auth,
+		httpConfig:           cfg.HTTP,
+		userPools:            newUserPools(cfg.Credentials),
+		rateLimiter:          newRateLimiter(cfg.RateLimits),
+	}
+	if err := checkRateLimitTools(cfg.RateLimits, ms.tools()); err != nil {
+		ms.Close()
+		return nil, err
+	}
+	return ms, nil
 }
 
 func (ms *MySQLServer) Close() error {
-	if ms.db != nil {
-		return ms.db.Close()
+	if ms.audit != nil {
+		ms.audit.Close()
+	}
+	ms.userPools.Close()
+	var firstErr error
+	for _, conn := range ms.connections {
+		if err := conn.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	return nil
+	return firstErr
 }
 
 // CreateMCPServerWithTools creates an MCP server instance with all tools registered
 func CreateMCPServerWithTools(ms *MySQLServer) *server.MCPServer {
+	hooks := &server.Hooks{}
+	ms.calls.registerHooks(hooks)
+
+	opts := []server.ServerOption{
+		server.WithToolCapabilities(true),
+		server.WithHooks(hooks),
+		server.WithToolHandlerMiddleware(ms.calls.middleware),
+	}
+	if ms.audit != nil {
+		// Inside the cancellation middleware to see the request ID, and
+		// outside the budget middleware to see the final result
+		opts = append(opts, server.WithToolHandlerMiddleware(ms.audit.middleware))
+	}
+	// Tool calls outside an HTTP caller's scopes are audited, but do not
+	// count against its rate limits
+	opts = append(opts, server.WithToolHandlerMiddleware(ms.scopeMiddleware))
+	if ms.rateLimiter != nil {
+		// Inside the audit middleware so that refused calls are logged, and
+		// outside the budget middleware to count the bytes sent
+		opts = append(opts, server.WithToolHandlerMiddleware(ms.rateLimitMiddleware))
+	}
+	opts = append(opts,
+		server.WithToolHandlerMiddleware(ms.budgetMiddleware),
+		// Only list the tools an HTTP caller may use
+		server.WithToolFilter(func(ctx context.Context, tools []mcp.Tool) []mcp.Tool {
+			id := identityFromContext(ctx)
+			var allowed []mcp.Tool
+			for _, tool := range tools {
+				if id.allowsTool(tool.Name) {
+					allowed = append(allowed, tool)
+				}
+			}
+			return allowed
+		}),
+	)
+
 	s := server.NewMCPServer(
 		"MySQL MCP Server",
 		"1.0.0",
-		server.WithToolCapabilities(true),
+		opts...,
 	)
 
+	// Abort the running query when the client cancels a tool call
+	s.AddNotificationHandler("notifications/cancelled", ms.calls.handleCancelled)
+
+	s.AddTools(ms.tools()...)
+	return s
+}
+
+// tools returns the tools of the server with their handlers.
+func (ms *MySQLServer) tools() []server.ServerTool {
+	var tools []server.ServerTool
+
 	// List schemas tool
 	listSchemasTool := mcp.NewTool("list_schemas",
 		mcp.WithDescription("List all schemas/databases available in the MySQL server with pagination"),
-		mcp.WithNumber("page",
-			mcp.Description("Page number (1-based)"),
+		mcp.WithString("cursor",
+			mcp.Description("The next_cursor of the previous page; omit for the first page"),
 		),
 		mcp.WithNumber("page_size",
 			mcp.Description("Number of items per page (default: 20, max: 100)"),
 		),
+		mcp.WithBoolean("include_total",
+			mcp.Description("Also count all matching items (default: false)"),
+		),
+		mcp.WithString("name_like",
+			mcp.Description("Only schemas whose name matches this LIKE pattern, e.g. app_%"),
+		),
+		mcp.WithString("name_regex",
+			mcp.Description("Only schemas whose name matches this regular expression (MySQL REGEXP)"),
+		),
+		mcp.WithString("format",
+			mcp.Description(formatArgDescription),
+			mcp.Enum(outputFormats...),
+		),
+		mcp.WithString("connection",
+			mcp.Description(connectionArgDescription),
+		),
 	)
-	s.AddTool(listSchemasTool, ms.listSchemasHandler)
+	tools = append(tools, server.ServerTool{Tool: listSchemasTool, Handler: ms.listSchemasHandler})
 
 	// List tables tool
 	listTablesTool := mcp.NewTool("list_tables",
@@ -87,14 +227,34 @@ func CreateMCPServerWithTools(ms *MySQLServer) *server.MCPServer {
 			mcp.Required(),
 			mcp.Description("The schema/database name"),
 		),
-		mcp.WithNumber("page",
-			mcp.Description("Page number (1-based)"),
+		mcp.WithString("table_type",
+			mcp.Description("Only tables of this type: BASE TABLE, VIEW or SYSTEM VIEW"),
+			mcp.Enum(tableTypes...),
+		),
+		mcp.WithString("cursor",
+			mcp.Description("The next_cursor of the previous page; omit for the first page"),
 		),
 		mcp.WithNumber("page_size",
 			mcp.Description("Number of items per page (default: 20, max: 100)"),
 		),
+		mcp.WithBoolean("include_total",
+			mcp.Description("Also count all matching items (default: false)"),
+		),
+		mcp.WithString("name_like",
+			mcp.Description("Only tables whose name matches this LIKE pattern, e.g. order%"),
+		),
+		mcp.WithString("name_regex",
+			mcp.Description("Only tables whose name matches this regular expression (MySQL REGEXP)"),
+		),
+		mcp.WithString("format",
+			mcp.Description(formatArgDescription),
+			mcp.Enum(outputFormats...),
+		),
+		mcp.WithString("connection",
+			mcp.Description(connectionArgDescription),
+		),
 	)
-	s.AddTool(listTablesTool, ms.listTablesHandler)
+	tools = append(tools, server.ServerTool{Tool: listTablesTool, Handler: ms.listTablesHandler})
 
 	// Get table create statement tool
 	getTableCreateTool := mcp.NewTool("get_table_create",
@@ -107,8 +267,11 @@ func CreateMCPServerWithTools(ms *MySQLServer) *server.MCPServer {
 			mcp.Required(),
 			mcp.Description("The table name"),
 		),
+		mcp.WithString("connection",
+			mcp.Description(connectionArgDescription),
+		),
 	)
-	s.AddTool(getTableCreateTool, ms.getTableCreateHandler)
+	tools = append(tools, server.ServerTool{Tool: getTableCreateTool, Handler: ms.getTableCreateHandler})
 
 	// Execute query tool
 	executeQueryTool := mcp.NewTool("execute_query",
@@ -118,10 +281,51 @@ func CreateMCPServerWithTools(ms *MySQLServer) *server.MCPServer {
 			mcp.Description("The SQL query to execute (SELECT statements only)"),
 		),
 		mcp.WithNumber("limit",
-			mcp.Description("Maximum number of rows to return (default: 100)"),
+			mcp.Description("Maximum number of rows to return when the query has no LIMIT (default: 100, max: 1000). A larger LIMIT in the query is clamped to 1000. When more rows are available the result includes a cursor for fetch_more"),
+		),
+		mcp.WithArray("params",
+			mcp.Description("Values bound to ? placeholders in the query, in order. Each item is a JSON value or an object {\"type\": \"string|int|decimal|datetime|null|bytes\", \"value\": ...}; bytes are base64 encoded"),
+		),
+		mcp.WithBoolean("force",
+			mcp.Description("Run the query even if its estimated cost exceeds the server's limits (default: false)"),
+		),
+		mcp.WithString("row_format",
+			mcp.Description("How rows are returned in the json format: object (column name to value, the default) or array (values in column order, keeps columns with duplicate names)"),
+			mcp.Enum(RowFormatObject, RowFormatArray),
+		),
+		mcp.WithString("format",
+			mcp.Description(formatArgDescription),
+			mcp.Enum(outputFormats...),
+		),
+		mcp.WithString("connection",
+			mcp.Description(connectionArgDescription),
 		),
 	)
-	s.AddTool(executeQueryTool, ms.executeQueryHandler)
+	tools = append(tools, server.ServerTool{Tool: executeQueryTool, Handler: ms.executeQueryHandler})
+
+	// Fetch more rows tool
+	fetchMoreTool := mcp.NewTool("fetch_more",
+		mcp.WithDescription("Fetch the next rows of an execute_query result using the cursor it returned"),
+		mcp.WithString("cursor",
+			mcp.Required(),
+			mcp.Description("The cursor from the previous execute_query or fetch_more result"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("Maximum number of rows to return (default: the limit of the original query, max: 1000)"),
+		),
+		mcp.WithString("format",
+			mcp.Description(formatArgDescription),
+			mcp.Enum(outputFormats...),
+		),
+		mcp.WithString("row_format",
+			mcp.Description("How rows are returned in the json format: object (column name to value, the default) or array (values in column order, keeps columns with duplicate names)"),
+			mcp.Enum(RowFormatObject, RowFormatArray),
+		),
+		mcp.WithString("connection",
+			mcp.Description(connectionArgDescription),
+		),
+	)
+	tools = append(tools, server.ServerTool{Tool: fetchMoreTool, Handler: ms.fetchMoreHandler})
 
 	// Search in table tool
 	searchTableTool := mcp.NewTool("search_table",
@@ -139,10 +343,17 @@ func CreateMCPServerWithTools(ms *MySQLServer) *server.MCPServer {
 			mcp.Description("The term to search for"),
 		),
 		mcp.WithNumber("limit",
-			mcp.Description("Maximum number of rows to return (default: 100)"),
+			mcp.Description("Maximum number of rows to return (default: 100, max: 1000)"),
+		),
+		mcp.WithString("format",
+			mcp.Description(formatArgDescription),
+			mcp.Enum(outputFormats...),
+		),
+		mcp.WithString("connection",
+			mcp.Description(connectionArgDescription),
 		),
 	)
-	s.AddTool(searchTableTool, ms.searchTableHandler)
+	tools = append(tools, server.ServerTool{Tool: searchTableTool, Handler: ms.searchTableHandler})
 
 	// Get table structure tool
 	getTableStructureTool := mcp.NewTool("get_table_structure",
@@ -155,8 +366,40 @@ func CreateMCPServerWithTools(ms *MySQLServer) *server.MCPServer {
 			mcp.Required(),
 			mcp.Description("The table name"),
 		),
+		mcp.WithString("connection",
+			mcp.Description(connectionArgDescription),
+		),
 	)
-	s.AddTool(getTableStructureTool, ms.getTableStructureHandler)
+	tools = append(tools, server.ServerTool{Tool: getTableStructureTool, Handler: ms.getTableStructureHandler})
 
-	return s
+	// Classify columns tool
+	classifyColumnsTool := mcp.NewTool("classify_columns",
+		mcp.WithDescription("Find columns likely to hold personal or sensitive data (emails, phone numbers, IBANs, card numbers, IP addresses, JWTs, names, addresses, secrets) from their names, types and a sample of values. Sampled values are not returned. The mask_columns field can be used as MYSQL_MASK_COLUMNS"),
+		mcp.WithString("schema",
+			mcp.Required(),
+			mcp.Description("The schema/database name"),
+		),
+		mcp.WithString("table",
+			mcp.Required(),
+			mcp.Description("The table name"),
+		),
+		mcp.WithNumber("sample_size",
+			mcp.Description("Number of rows to sample (default: 100, max: 1000, 0 to classify by name and type only)"),
+		),
+		mcp.WithNumber("min_confidence",
+			mcp.Description("Only report columns with at least this confidence between 0 and 1 (default: 0.5)"),
+		),
+		mcp.WithString("connection",
+			mcp.Description(connectionArgDescription),
+		),
+	)
+	tools = append(tools, server.ServerTool{Tool: classifyColumnsTool, Handler: ms.classifyColumnsHandler})
+
+	// List connections tool
+	listConnectionsTool := mcp.NewTool("list_connections",
+		mcp.WithDescription("List the named database connections the other tools can use with their connection argument, with each one's host, server version and read-only status"),
+	)
+	tools = append(tools, server.ServerTool{Tool: listConnectionsTool, Handler: ms.listConnectionsHandler})
+
+	return tools
 }
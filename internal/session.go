@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Read-only safety modes applied to the connection of every tool call
+const (
+	// ReadOnlyTransaction runs each tool call inside START TRANSACTION READ ONLY
+	// and always rolls it back.
+	ReadOnlyTransaction = "transaction"
+	// ReadOnlySession sets transaction_read_only on the connection before use.
+	ReadOnlySession = "session"
+	// ReadOnlyOff relies on statement inspection and MySQL grants only.
+	ReadOnlyOff = "off"
+)
+
+// queryer is the subset of *sql.Conn and *sql.Tx used by the tool handlers.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// killTimeout bounds the KILL QUERY issued for a cancelled tool call.
+const killTimeout = 5 * time.Second
+
+// dbSession is the dedicated connection used by a single tool call.
+type dbSession struct {
+	queryer
+	conn   *sql.Conn
+	tx     *sql.Tx
+	cancel context.CancelFunc
+	// done stops the kill goroutine, which closes stopped when it exits
+	done    chan struct{}
+	stopped chan struct{}
+	// killed is set when the kill goroutine issued KILL QUERY
+	killed bool
+	// release returns the pool of a per-user session
+	release func()
+
+	// tzOffset is the session time zone as an ISO-8601 offset
+	tzOffset string
+	// database is the default schema of the connection, if any
+	database string
+	// budget bounds the size of the rows read in this session
+	budget responseBudget
+}
+
+// beginSession reserves a connection for one tool call and applies the
+// configured read-only mode to it, so that even a statement slipping past
+// the parser cannot modify data. The returned context carries the statement
+// timeout; when it is cancelled or expires the running statement is killed
+// on the server. The session must be closed by the caller.
+func (ms *MySQLServer) beginSession(ctx context.Context, c *dbConnection) (context.Context, *dbSession, error) {
+	var cancel context.CancelFunc
+	if ms.queryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, ms.queryTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	// HTTP callers may run as their own MySQL user
+	db, release, err := ms.userPools.acquire(ctx, c)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		release()
+		cancel()
+		return nil, nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	sess := &dbSession{queryer: conn, conn: conn, cancel: cancel, done: make(chan struct{}), release: release, budget: ms.budget}
+
+	// Remember the server thread so a cancelled statement can be killed;
+	// closing the client side of the connection does not stop it
+	var connID int64
+	var tzDiff string
+	var database sql.NullString
+	if err := conn.QueryRowContext(ctx, "SELECT CONNECTION_ID(), TIMEDIFF(NOW(), UTC_TIMESTAMP()), DATABASE()").Scan(&connID, &tzDiff, &database); err != nil {
+		sess.Close()
+		return nil, nil, fmt.Errorf("failed to get connection info: %w", err)
+	}
+	sess.tzOffset = formatTZOffset(tzDiff)
+	sess.database = database.String
+	sess.stopped = make(chan struct{})
+	go sess.killOnCancel(ctx, db, connID)
+
+	switch ms.readOnlyMode {
+	case ReadOnlyTransaction:
+		tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			sess.Close()
+			return nil, nil, fmt.Errorf("failed to start read-only transaction: %w", err)
+		}
+		sess.queryer = tx
+		sess.tx = tx
+	case ReadOnlySession:
+		if _, err := conn.ExecContext(ctx, "SET SESSION transaction_read_only = 1"); err != nil {
+			sess.Close()
+			return nil, nil, fmt.Errorf("failed to enable read-only session: %w", err)
+		}
+	}
+
+	return ctx, sess, nil
+}
+
+// killOnCancel issues KILL QUERY for connID of db when ctx ends before the
+// session is closed.
+func (s *dbSession) killOnCancel(ctx context.Context, db *sql.DB, connID int64) {
+	defer close(s.stopped)
+	select {
+	case <-s.done:
+		return
+	case <-ctx.Done():
+	}
+	s.killed = true
+
+	killCtx, cancel := context.WithTimeout(context.Background(), killTimeout)
+	defer cancel()
+
+	if _, err := db.ExecContext(killCtx, fmt.Sprintf("KILL QUERY %d", connID)); err != nil {
+		log.Printf("Failed to kill query on connection %d: %v", connID, err)
+	}
+}
+
+// Close rolls back the read-only transaction, if any, and returns the
+// connection to the pool. It waits for a KILL QUERY in progress, and then
+// discards the connection, since the KILL can abort the next statement run
+// on it.
+func (s *dbSession) Close() error {
+	close(s.done)
+	if s.stopped != nil {
+		<-s.stopped
+	}
+	defer s.cancel()
+	defer s.release()
+
+	if s.tx != nil {
+		// Nothing is ever committed; a rollback also releases metadata locks
+		if err := s.tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			s.conn.Close()
+			return fmt.Errorf("failed to roll back read-only transaction: %w", err)
+		}
+	}
+	if s.killed {
+		s.conn.Raw(func(any) error { return driver.ErrBadConn })
+		return nil
+	}
+	return s.conn.Close()
+}
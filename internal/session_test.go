@@ -0,0 +1,290 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// errReadOnlyTransaction is the error MySQL returns for a write in a
+// read-only transaction or session.
+const errReadOnlyTransaction = 1792
+
+// fakeDB is a database/sql connector that records the statements it
+// receives and, like MySQL, refuses writes in read-only transactions and
+// in sessions with transaction_read_only set.
+type fakeDB struct {
+	// tableColumns are the COLUMN_NAME and DATA_TYPE rows returned for
+	// queries on information_schema.COLUMNS
+	tableColumns [][]driver.Value
+
+	mu         sync.Mutex
+	statements []string
+}
+
+func (d *fakeDB) open() *sql.DB {
+	return sql.OpenDB(d)
+}
+
+func (d *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: d}, nil
+}
+
+func (d *fakeDB) Driver() driver.Driver {
+	return fakeDriver{d}
+}
+
+func (d *fakeDB) record(query string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statements = append(d.statements, query)
+}
+
+// received returns the statements received so far.
+func (d *fakeDB) received() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.statements...)
+}
+
+type fakeDriver struct {
+	db *fakeDB
+}
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	return d.db.Connect(context.Background())
+}
+
+// writeKeywords start statements MySQL refuses in read-only mode.
+var writeKeywords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "REPLACE": true,
+	"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "RENAME": true,
+}
+
+type fakeConn struct {
+	db              *fakeDB
+	readOnlySession bool
+	readOnlyTx      bool
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.ReadOnly {
+		c.db.record("START TRANSACTION READ ONLY")
+	} else {
+		c.db.record("START TRANSACTION")
+	}
+	c.readOnlyTx = opts.ReadOnly
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.readOnlyTx = false
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.readOnlyTx = false
+	return nil
+}
+
+func (c *fakeConn) run(query string) error {
+	c.db.record(query)
+	if query == "SET SESSION transaction_read_only = 1" {
+		c.readOnlySession = true
+		return nil
+	}
+	fields := strings.Fields(query)
+	if len(fields) > 0 && writeKeywords[strings.ToUpper(fields[0])] && (c.readOnlyTx || c.readOnlySession) {
+		return &mysql.MySQLError{Number: errReadOnlyTransaction, Message: "Cannot execute statement in a READ ONLY transaction."}
+	}
+	return nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if err := c.run(query); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if err := c.run(query); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(query, "SELECT CONNECTION_ID()") {
+		return &fakeRows{columns: []string{"id", "tz", "db"}, rows: [][]driver.Value{{int64(7), "00:00:00", nil}}}, nil
+	}
+	if strings.Contains(query, "information_schema.COLUMNS") {
+		return &fakeRows{columns: []string{"COLUMN_NAME", "DATA_TYPE"}, rows: append([][]driver.Value(nil), c.db.tableColumns...)}, nil
+	}
+	return &fakeRows{columns: []string{"1"}}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// newTestServer returns a server with a single connection to db.
+func newTestServer(db *sql.DB, readOnlyMode string) *MySQLServer {
+	conn := &dbConnection{name: DefaultConnectionName, profile: &ProfileConfig{}, config: mysql.NewConfig(), db: db}
+	return &MySQLServer{
+		connections:  map[string]*dbConnection{conn.name: conn},
+		primary:      conn.name,
+		readOnlyMode: readOnlyMode,
+		queryTimeout: DefaultQueryTimeout,
+		calls:        newToolCalls(),
+		paging:       PagingConfig{DefaultPageSize: DefaultPageSize, MaxPageSize: MaxPageSize, DefaultQueryLimit: DefaultQueryLimit, MaxQueryLimit: MaxQueryLimit},
+		output:       OutputConfig{Format: FormatJSON, RowFormat: RowFormatObject},
+		access:       &accessPolicy{},
+		masking:      &maskingPolicy{},
+	}
+}
+
+var writeStatements = []string{
+	"INSERT INTO users (name) VALUES ('x')",
+	"UPDATE users SET name = 'x'",
+	"DELETE FROM users",
+	"CREATE TABLE copies (id INT)",
+	"ALTER TABLE users ADD COLUMN x INT",
+	"DROP TABLE users",
+}
+
+// TestReadOnlyModesRejectWrites checks that the transaction and session
+// modes put the connection into a state in which the server refuses writes,
+// should a statement get past the parser.
+func TestReadOnlyModesRejectWrites(t *testing.T) {
+	for _, mode := range []string{ReadOnlyTransaction, ReadOnlySession} {
+		for _, query := range writeStatements {
+			t.Run(mode+"/"+query, func(t *testing.T) {
+				fake := &fakeDB{}
+				ms := newTestServer(fake.open(), mode)
+
+				ctx, sess, err := ms.beginSession(context.Background(), ms.connections[ms.primary])
+				if err != nil {
+					t.Fatalf("beginSession: %v", err)
+				}
+				defer sess.Close()
+
+				rows, err := sess.QueryContext(ctx, query)
+				if err == nil {
+					rows.Close()
+					t.Fatalf("%s succeeded in %s mode", query, mode)
+				}
+				var mysqlErr *mysql.MySQLError
+				if !errors.As(err, &mysqlErr) || mysqlErr.Number != errReadOnlyTransaction {
+					t.Fatalf("got error %v, want MySQL error %d", err, errReadOnlyTransaction)
+				}
+			})
+		}
+	}
+}
+
+func TestReadOnlyModeOffLeavesSessionWritable(t *testing.T) {
+	fake := &fakeDB{}
+	ms := newTestServer(fake.open(), ReadOnlyOff)
+
+	ctx, sess, err := ms.beginSession(context.Background(), ms.connections[ms.primary])
+	if err != nil {
+		t.Fatalf("beginSession: %v", err)
+	}
+	defer sess.Close()
+
+	// Without a read-only mode only the parser and the grants stop writes
+	rows, err := sess.QueryContext(ctx, writeStatements[0])
+	if err != nil {
+		t.Fatalf("got error %v in %s mode", err, ReadOnlyOff)
+	}
+	rows.Close()
+	for _, stmt := range fake.received() {
+		if strings.Contains(stmt, "READ ONLY") || strings.Contains(stmt, "transaction_read_only") {
+			t.Fatalf("%s mode ran %q", ReadOnlyOff, stmt)
+		}
+	}
+}
+
+// TestReadOnlyModesOnServer runs the write statements against the server
+// of MYSQL_TEST_DSN, whose user must be allowed to create and drop tables
+// in its database.
+func TestReadOnlyModesOnServer(t *testing.T) {
+	dsn := os.Getenv("MYSQL_TEST_DSN")
+	if dsn == "" {
+		t.Skip("MYSQL_TEST_DSN is not set")
+	}
+	cfg, err := parseDSN(dsn)
+	if err != nil {
+		t.Fatalf("invalid MYSQL_TEST_DSN: %v", err)
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		t.Fatalf("invalid MYSQL_TEST_DSN: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "CREATE TABLE users (id INT PRIMARY KEY, name VARCHAR(20))"); err != nil {
+		t.Fatalf("failed to create test table: %v", err)
+	}
+	defer db.ExecContext(ctx, "DROP TABLE IF EXISTS users, copies")
+
+	for _, mode := range []string{ReadOnlyTransaction, ReadOnlySession} {
+		ms := newTestServer(db, mode)
+		for _, query := range writeStatements {
+			t.Run(mode+"/"+query, func(t *testing.T) {
+				ctx, sess, err := ms.beginSession(ctx, ms.connections[ms.primary])
+				if err != nil {
+					t.Fatalf("beginSession: %v", err)
+				}
+				defer sess.Close()
+
+				rows, err := sess.QueryContext(ctx, query)
+				if err == nil {
+					rows.Close()
+					t.Fatalf("%s succeeded in %s mode", query, mode)
+				}
+				var mysqlErr *mysql.MySQLError
+				if !errors.As(err, &mysqlErr) || mysqlErr.Number != errReadOnlyTransaction {
+					t.Fatalf("got error %v, want MySQL error %d", err, errReadOnlyTransaction)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	_ "github.com/pingcap/tidb/pkg/parser/test_driver"
+)
+
+// deniedFunctions lists functions that block, take locks, read server files
+// or otherwise have side effects even when called from a SELECT.
+var deniedFunctions = map[string]bool{
+	"sleep":                             true,
+	"benchmark":                         true,
+	"get_lock":                          true,
+	"release_lock":                      true,
+	"release_all_locks":                 true,
+	"is_free_lock":                      true,
+	"is_used_lock":                      true,
+	"load_file":                         true,
+	"master_pos_wait":                   true,
+	"source_pos_wait":                   true,
+	"wait_for_executed_gtid_set":        true,
+	"wait_until_sql_thread_after_gtids": true,
+	"sys_exec":                          true,
+	"sys_eval":                          true,
+	"nextval":                           true,
+	"setval":                            true,
+	"lastval":                           true,
+}
+
+// parseStatement parses query using the MySQL grammar and returns the single
+// statement it contains.
+func parseStatement(query string) (ast.StmtNode, error) {
+	stmts, _, err := parser.New().Parse(query, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse query: %w", err)
+	}
+
+	switch len(stmts) {
+	case 0:
+		return nil, fmt.Errorf("query contains no SQL statement")
+	case 1:
+		return stmts[0], nil
+	default:
+		return nil, fmt.Errorf("query rejected: multiple statements are not allowed (found %d)", len(stmts))
+	}
+}
+
+// parseReadOnlyStatement parses query and verifies that it is a single
+// SELECT, SHOW, DESCRIBE or EXPLAIN statement free of side effects.
+func parseReadOnlyStatement(query string) (ast.StmtNode, error) {
+	stmt, err := parseStatement(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkReadOnlyStatement(stmt); err != nil {
+		return nil, fmt.Errorf("query rejected: %w", err)
+	}
+
+	return stmt, nil
+}
+
+func checkReadOnlyStatement(stmt ast.StmtNode) error {
+	switch s := stmt.(type) {
+	case *ast.SelectStmt, *ast.SetOprStmt, *ast.ShowStmt:
+	case *ast.ExplainStmt:
+		// DESCRIBE is parsed as an EXPLAIN wrapping a SHOW statement
+		switch s.Stmt.(type) {
+		case *ast.ShowStmt, *ast.SelectStmt, *ast.SetOprStmt:
+		default:
+			return fmt.Errorf("EXPLAIN is only allowed for SELECT statements, got %s", statementKind(s.Stmt))
+		}
+	default:
+		return fmt.Errorf("only SELECT, SHOW, DESCRIBE, and EXPLAIN statements are allowed, got %s", statementKind(stmt))
+	}
+
+	checker := &readOnlyChecker{}
+	stmt.Accept(checker)
+	return checker.err
+}
+
+// statementKind returns a human readable name for the statement type.
+func statementKind(stmt ast.StmtNode) string {
+	switch s := stmt.(type) {
+	case *ast.SelectStmt:
+		switch s.Kind {
+		case ast.SelectStmtKindTable:
+			return "TABLE"
+		case ast.SelectStmtKindValues:
+			return "VALUES"
+		}
+		return "SELECT"
+	case *ast.SetOprStmt:
+		return "SELECT"
+	case *ast.ShowStmt:
+		return "SHOW"
+	case *ast.ExplainStmt:
+		if _, ok := s.Stmt.(*ast.ShowStmt); ok {
+			return "DESCRIBE"
+		}
+		return "EXPLAIN"
+	case *ast.InsertStmt:
+		if s.IsReplace {
+			return "REPLACE"
+		}
+		return "INSERT"
+	case *ast.UpdateStmt:
+		return "UPDATE"
+	case *ast.DeleteStmt:
+		return "DELETE"
+	case *ast.SetStmt:
+		return "SET"
+	case *ast.CallStmt:
+		return "CALL"
+	case *ast.LoadDataStmt:
+		return "LOAD DATA"
+	}
+
+	// Derive the keyword from the node type, e.g. *ast.DropTableStmt -> DROP TABLE
+	name := strings.TrimSuffix(strings.TrimPrefix(fmt.Sprintf("%T", stmt), "*ast."), "Stmt")
+	var words []string
+	start := 0
+	for i := 1; i < len(name); i++ {
+		if name[i] >= 'A' && name[i] <= 'Z' {
+			words = append(words, name[start:i])
+			start = i
+		}
+	}
+	words = append(words, name[start:])
+	return strings.ToUpper(strings.Join(words, " "))
+}
+
+// readOnlyChecker walks a statement and records the first construct that
+// could lock rows, write files, block the server or change session state.
+type readOnlyChecker struct {
+	err error
+}
+
+func (c *readOnlyChecker) Enter(n ast.Node) (ast.Node, bool) {
+	if c.err != nil {
+		return n, true
+	}
+
+	switch node := n.(type) {
+	case *ast.SelectStmt:
+		if node.LockInfo != nil && node.LockInfo.LockType != ast.SelectLockNone {
+			c.err = fmt.Errorf("locking clause %s is not allowed", strings.ToUpper(node.LockInfo.LockType.String()))
+		} else if node.SelectIntoOpt != nil {
+			c.err = fmt.Errorf("SELECT ... INTO is not allowed")
+		}
+	case *ast.FuncCallExpr:
+		if deniedFunctions[node.FnName.L] {
+			c.err = fmt.Errorf("function %s() is not allowed", strings.ToUpper(node.FnName.O))
+		}
+	case *ast.VariableExpr:
+		if node.Value != nil {
+			c.err = fmt.Errorf("variable assignment is not allowed")
+		}
+	}
+
+	return n, c.err != nil
+}
+
+func (c *readOnlyChecker) Leave(n ast.Node) (ast.Node, bool) {
+	return n, c.err == nil
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestParseReadOnlyStatementRejectsWrites(t *testing.T) {
+	for _, query := range []string{
+		"INSERT INTO users (name) VALUES ('x')",
+		"REPLACE INTO users (id, name) VALUES (1, 'x')",
+		"UPDATE users SET name = 'x'",
+		"DELETE FROM users",
+		"CREATE TABLE copies (id INT)",
+		"ALTER TABLE users ADD COLUMN x INT",
+		"DROP TABLE users",
+		"TRUNCATE TABLE users",
+		"RENAME TABLE users TO old_users",
+		"CREATE INDEX idx ON users (name)",
+		"GRANT ALL ON *.* TO 'x'@'%'",
+		"SET SESSION transaction_read_only = 0",
+		"CALL cleanup()",
+		"LOAD DATA INFILE '/tmp/x' INTO TABLE users",
+		"SELECT * FROM users FOR UPDATE",
+		"SELECT * FROM users LOCK IN SHARE MODE",
+		"SELECT * FROM users INTO OUTFILE '/tmp/users'",
+		"SELECT @x := 1",
+		"SELECT SLEEP(10)",
+		"SELECT LOAD_FILE('/etc/passwd')",
+		"EXPLAIN DELETE FROM users",
+		"SELECT 1; DROP TABLE users",
+		"SELECT 1; SELECT 2",
+	} {
+		if _, err := parseReadOnlyStatement(query); err == nil {
+			t.Errorf("%q was accepted", query)
+		}
+	}
+}
+
+func TestParseReadOnlyStatementAcceptsReads(t *testing.T) {
+	for _, query := range []string{
+		"SELECT * FROM users",
+		"WITH recent AS (SELECT * FROM orders) SELECT * FROM recent",
+		"SELECT id FROM users UNION SELECT id FROM admins",
+		"TABLE users",
+		"SHOW TABLES",
+		"DESCRIBE users",
+		"EXPLAIN SELECT * FROM users",
+	} {
+		if _, err := parseReadOnlyStatement(query); err != nil {
+			t.Errorf("%q was rejected: %v", query, err)
+		}
+	}
+}
+
+// TestRejectedStatementsNeverReachDatabase checks that execute_query
+// refuses writes before it uses a connection, in every read-only mode.
+func TestRejectedStatementsNeverReachDatabase(t *testing.T) {
+	for _, mode := range []string{ReadOnlyTransaction, ReadOnlySession, ReadOnlyOff} {
+		for _, query := range []string{
+			"INSERT INTO users (name) VALUES ('x')",
+			"UPDATE users SET name = 'x'",
+			"DROP TABLE users",
+			"SELECT 1; DELETE FROM users",
+		} {
+			fake := &fakeDB{}
+			ms := newTestServer(fake.open(), mode)
+
+			var request mcp.CallToolRequest
+			request.Params.Name = "execute_query"
+			request.Params.Arguments = map[string]any{"query": query}
+			_, err := ms.executeQueryHandler(context.Background(), request)
+			if err == nil || !strings.Contains(err.Error(), "query rejected") {
+				t.Errorf("%s mode: %q: got error %v, want a rejection", mode, query, err)
+			}
+			if received := fake.received(); len(received) > 0 {
+				t.Errorf("%s mode: %q reached the database: %q", mode, query, received)
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/format"
+	driver "github.com/pingcap/tidb/pkg/parser/test_driver"
+)
+
+// maxAliasLength is the longest column alias MySQL accepts.
+const maxAliasLength = 256
+
+// limitRows rewrites the outermost LIMIT of a SELECT statement so that no
+// more than limit rows are produced. When the statement has no LIMIT one is
+// added, and a user supplied LIMIT larger than maxLimit is clamped. One
+// extra row is requested so the caller can tell whether the result was cut
+// off. A LIMIT given as a ? placeholder is clamped by adjusting its bound
+// value in args. It returns the number of rows to return and whether stmt
+// was modified. Statements without a LIMIT clause (SHOW, DESCRIBE, EXPLAIN)
+// are left unchanged.
+func limitRows(stmt ast.StmtNode, limit, maxLimit int, args []any) (int, bool, error) {
+	var current **ast.Limit
+	switch s := stmt.(type) {
+	case *ast.SelectStmt:
+		current = &s.Limit
+	case *ast.SetOprStmt:
+		current = &s.Limit
+	default:
+		return limit, false, nil
+	}
+
+	if *current == nil {
+		*current = &ast.Limit{Count: ast.NewValueExpr(uint64(limit+1), "", "")}
+		return limit, true, nil
+	}
+
+	if marker, ok := (*current).Count.(*driver.ParamMarkerExpr); ok {
+		for i, m := range paramMarkers(stmt) {
+			if m != marker || i >= len(args) {
+				continue
+			}
+			count, ok := paramInt(args[i])
+			if !ok {
+				return 0, false, fmt.Errorf("query rejected: LIMIT parameter must be a non-negative integer")
+			}
+			if count <= uint64(maxLimit) {
+				return int(count), false, nil
+			}
+			args[i] = int64(maxLimit + 1)
+			return maxLimit, false, nil
+		}
+		return 0, false, fmt.Errorf("query rejected: no parameter bound for LIMIT placeholder")
+	}
+
+	count, err := limitCount(*current)
+	if err != nil {
+		return 0, false, err
+	}
+	if count <= uint64(maxLimit) {
+		// The user's own LIMIT is within bounds
+		return int(count), false, nil
+	}
+	(*current).Count = ast.NewValueExpr(uint64(maxLimit+1), "", "")
+	return maxLimit, true, nil
+}
+
+// addExecutionTimeHint adds a MAX_EXECUTION_TIME optimizer hint to a SELECT
+// so the server aborts it on its own once timeout has passed. An existing
+// hint with a longer time is lowered. It reports whether stmt was modified.
+func addExecutionTimeHint(stmt ast.StmtNode, timeout time.Duration) bool {
+	millis := uint64(timeout.Milliseconds())
+	if millis == 0 {
+		return false
+	}
+
+	// The hint must appear after the first SELECT keyword of the statement
+	sel := firstSelect(stmt)
+	if sel == nil || sel.Kind != ast.SelectStmtKindSelect {
+		return false
+	}
+
+	for _, hint := range sel.TableHints {
+		if hint.HintName.L == "max_execution_time" {
+			if current, ok := hint.HintData.(uint64); ok && current > 0 && current <= millis {
+				return false
+			}
+			hint.HintData = millis
+			return true
+		}
+	}
+
+	sel.TableHints = append(sel.TableHints, &ast.TableOptimizerHint{
+		HintName: ast.NewCIStr("max_execution_time"),
+		HintData: millis,
+	})
+	return true
+}
+
+// limitCount returns the constant row count of a LIMIT clause.
+func limitCount(limit *ast.Limit) (uint64, error) {
+	if value, ok := limit.Count.(ast.ValueExpr); ok {
+		switch v := value.GetValue().(type) {
+		case int64:
+			if v >= 0 {
+				return uint64(v), nil
+			}
+		case uint64:
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("query rejected: LIMIT must be a constant non-negative integer")
+}
+
+// restoreStatement renders a (possibly modified) statement back to SQL. The
+// output names of computed select fields are pinned with aliases taken from
+// the original query text, so rewriting does not change the column names
+// MySQL reports (e.g. COUNT(*) is otherwise restored as COUNT(1)).
+func restoreStatement(stmt ast.StmtNode) (string, error) {
+	if sel := firstSelect(stmt); sel != nil && sel.Fields != nil {
+		for _, field := range sel.Fields.Fields {
+			if field.WildCard != nil || field.AsName.O != "" {
+				continue
+			}
+			switch field.Expr.(type) {
+			case *ast.ColumnNameExpr, ast.ValueExpr:
+				continue
+			}
+			if name := strings.TrimSpace(field.Text()); name != "" {
+				field.AsName = ast.NewCIStr(truncateUTF8(name, maxAliasLength))
+			}
+		}
+	}
+
+	var sb strings.Builder
+	flags := format.DefaultRestoreFlags | format.RestoreStringEscapeBackslash | format.RestoreStringWithoutDefaultCharset
+	if err := stmt.Restore(format.NewRestoreCtx(flags, &sb)); err != nil {
+		return "", fmt.Errorf("failed to rewrite query: %w", err)
+	}
+	return sb.String(), nil
+}
+
+// firstSelect returns the SELECT whose field list names the result columns.
+func firstSelect(node ast.Node) *ast.SelectStmt {
+	switch s := node.(type) {
+	case *ast.SelectStmt:
+		return s
+	case *ast.SetOprStmt:
+		if s.SelectList != nil && len(s.SelectList.Selects) > 0 {
+			return firstSelect(s.SelectList.Selects[0])
+		}
+	case *ast.SetOprSelectList:
+		if len(s.Selects) > 0 {
+			return firstSelect(s.Selects[0])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// TLS modes of a MySQL connection
+const (
+	// TLSDisabled connects without TLS.
+	TLSDisabled = "disabled"
+	// TLSPreferred uses TLS when the server supports it, without verifying
+	// its certificate.
+	TLSPreferred = "preferred"
+	// TLSRequired requires TLS and verifies the server certificate and host
+	// name.
+	TLSRequired = "required"
+	// TLSSkipVerify requires TLS without verifying the server certificate.
+	TLSSkipVerify = "skip-verify"
+)
+
+// tlsModes lists the accepted values of tls_mode.
+var tlsModes = []string{TLSDisabled, TLSPreferred, TLSRequired, TLSSkipVerify}
+
+// tlsMode returns the TLS mode of p. A CA bundle, client certificate or
+// server name without a mode implies required.
+func (p *ProfileConfig) tlsMode() string {
+	switch {
+	case p.TLSMode != "":
+		return p.TLSMode
+	case p.hasTLSFiles():
+		return TLSRequired
+	default:
+		return TLSDisabled
+	}
+}
+
+// hasTLSFiles reports whether p sets a CA bundle, client certificate or
+// server name, which need a registered tls.Config.
+func (p *ProfileConfig) hasTLSFiles() bool {
+	return p.TLSCA != "" || p.TLSCert != "" || p.TLSServerName != ""
+}
+
+// applyTLS sets the TLS configuration of the connection name on cfg,
+// registering it with the driver when it uses files or a server name.
+// Without TLS settings in p the tls parameter of a DSN is kept.
+func applyTLS(name string, p *ProfileConfig, cfg *mysql.Config) error {
+	// The driver derives the tls.Config from TLSConfig, including the host
+	// name to verify, which may have changed since the DSN was parsed
+	cfg.TLS = nil
+	if p.TLSMode == "" && !p.hasTLSFiles() {
+		return nil
+	}
+
+	mode := p.tlsMode()
+	cfg.AllowFallbackToPlaintext = false
+
+	// The driver's own configurations cover the modes without files
+	if !p.hasTLSFiles() {
+		switch mode {
+		case TLSDisabled:
+			cfg.TLSConfig = "false"
+		case TLSRequired:
+			cfg.TLSConfig = "true"
+		default:
+			cfg.TLSConfig = mode
+		}
+		return nil
+	}
+
+	tlsCfg, err := p.tlsConfig()
+	if err != nil {
+		return err
+	}
+	key := "mysql-mcp-" + name
+	if err := mysql.RegisterTLSConfig(key, tlsCfg); err != nil {
+		return fmt.Errorf("failed to register TLS config: %w", err)
+	}
+	cfg.TLSConfig = key
+	cfg.AllowFallbackToPlaintext = mode == TLSPreferred
+	return nil
+}
+
+// connectionTLSMode returns the TLS mode a connection uses, from the
+// settings of p or else the tls parameter of its DSN.
+func connectionTLSMode(p *ProfileConfig, cfg *mysql.Config) string {
+	if p.TLSMode != "" || p.hasTLSFiles() {
+		return p.tlsMode()
+	}
+	switch cfg.TLSConfig {
+	case "", "false":
+		return TLSDisabled
+	case "true":
+		return TLSRequired
+	default:
+		return cfg.TLSConfig
+	}
+}
+
+// tlsConfig builds the TLS configuration of p from its CA bundle, client
+// certificate and server name. The driver verifies the host name against
+// the connection host unless a server name is set.
+func (p *ProfileConfig) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         p.TLSServerName,
+		InsecureSkipVerify: p.tlsMode() != TLSRequired,
+	}
+
+	if p.TLSCA != "" {
+		pem, err := os.ReadFile(p.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls_ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls_ca %s contains no PEM certificates", p.TLSCA)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if p.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(p.TLSCert, p.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls_cert and tls_key: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
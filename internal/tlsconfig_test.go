@@ -0,0 +1,303 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// testCert is a certificate issued by a test CA, or the CA itself.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var testSerial int64
+
+// issueTestCert creates a certificate for cn signed by ca, or a self-signed
+// CA when ca is nil.
+func issueTestCert(t *testing.T, ca *testCert, cn string, usage x509.ExtKeyUsage, dnsNames ...string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSerial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(testSerial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     dnsNames,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	parent, signer := tmpl, key
+	if ca == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		tmpl.ExtKeyUsage = nil
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// writeFiles writes the certificate and key as PEM files named name.pem and
+// name.key to dir.
+func (c *testCert) writeFiles(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+".key")
+	writeTestFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}))
+	writeTestFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testPKI is a CA with a server certificate for db.internal and a client
+// certificate, and an unrelated CA, all written to a temporary directory.
+type testPKI struct {
+	ca, server, client            *testCert
+	caFile, otherCAFile           string
+	clientCertFile, clientKeyFile string
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir := t.TempDir()
+	p := &testPKI{ca: issueTestCert(t, nil, "Test CA", 0)}
+	p.server = issueTestCert(t, p.ca, "db.internal", x509.ExtKeyUsageServerAuth, "db.internal")
+	p.client = issueTestCert(t, p.ca, "mcp-client", x509.ExtKeyUsageClientAuth)
+	p.caFile, _ = p.ca.writeFiles(t, dir, "ca")
+	p.otherCAFile, _ = issueTestCert(t, nil, "Other CA", 0).writeFiles(t, dir, "other-ca")
+	p.clientCertFile, p.clientKeyFile = p.client.writeFiles(t, dir, "client")
+	return p
+}
+
+// handshake connects to a TLS server presenting the server certificate,
+// which requires a client certificate of the CA when requireClient is set,
+// and returns the client certificate the server saw and the client error.
+// Like the driver, it verifies the connection host unless a server name is
+// set.
+func (p *testPKI) handshake(t *testing.T, cfg *tls.Config, requireClient bool) (*x509.Certificate, error) {
+	t.Helper()
+	serverCfg := &tls.Config{Certificates: []tls.Certificate{p.server.tlsCertificate()}}
+	if requireClient {
+		pool := x509.NewCertPool()
+		pool.AddCert(p.ca.cert)
+		serverCfg.ClientCAs = pool
+		serverCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	peer := make(chan *x509.Certificate, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			peer <- nil
+			return
+		}
+		defer conn.Close()
+		tlsConn := conn.(*tls.Conn)
+		if err := tlsConn.Handshake(); err != nil || len(tlsConn.ConnectionState().PeerCertificates) == 0 {
+			peer <- nil
+			return
+		}
+		peer <- tlsConn.ConnectionState().PeerCertificates[0]
+	}()
+
+	cfg = cfg.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = "127.0.0.1"
+	}
+	conn, err := tls.Dial("tcp", ln.Addr().String(), cfg)
+	if err != nil {
+		return <-peer, err
+	}
+	defer conn.Close()
+	// In TLS 1.3 the server's verdict on the client certificate arrives
+	// with the first read; after a handshake it accepted, the server closes
+	// the connection
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		return <-peer, err
+	}
+	return <-peer, nil
+}
+
+func TestTLSConfigRequired(t *testing.T) {
+	pki := newTestPKI(t)
+
+	cfg, err := (&ProfileConfig{TLSMode: TLSRequired, TLSCA: pki.caFile, TLSServerName: "db.internal"}).tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.InsecureSkipVerify || cfg.ServerName != "db.internal" {
+		t.Fatalf("required: InsecureSkipVerify %v, ServerName %q", cfg.InsecureSkipVerify, cfg.ServerName)
+	}
+	if _, err := pki.handshake(t, cfg, false); err != nil {
+		t.Fatalf("required with the server's CA and name: %v", err)
+	}
+
+	// The pinned name is verified rather than the connection host
+	cfg, err = (&ProfileConfig{TLSMode: TLSRequired, TLSCA: pki.caFile, TLSServerName: "other.internal"}).tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pki.handshake(t, cfg, false); err == nil {
+		t.Fatal("required accepted a certificate for another name")
+	}
+
+	// Without a server name the connection host is verified
+	cfg, err = (&ProfileConfig{TLSMode: TLSRequired, TLSCA: pki.caFile}).tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pki.handshake(t, cfg, false); err == nil {
+		t.Fatal("required accepted a certificate not issued for the host")
+	}
+
+	cfg, err = (&ProfileConfig{TLSMode: TLSRequired, TLSCA: pki.otherCAFile, TLSServerName: "db.internal"}).tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pki.handshake(t, cfg, false); err == nil {
+		t.Fatal("required accepted a certificate of an untrusted CA")
+	}
+}
+
+func TestTLSConfigWithoutVerification(t *testing.T) {
+	pki := newTestPKI(t)
+
+	for _, mode := range []string{TLSSkipVerify, TLSPreferred} {
+		cfg, err := (&ProfileConfig{TLSMode: mode, TLSCA: pki.otherCAFile}).tlsConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cfg.InsecureSkipVerify {
+			t.Errorf("%s verifies the server certificate", mode)
+		}
+		if _, err := pki.handshake(t, cfg, false); err != nil {
+			t.Errorf("%s with an untrusted certificate: %v", mode, err)
+		}
+	}
+}
+
+func TestTLSConfigClientCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+
+	cfg, err := (&ProfileConfig{
+		TLSMode:       TLSRequired,
+		TLSCA:         pki.caFile,
+		TLSServerName: "db.internal",
+		TLSCert:       pki.clientCertFile,
+		TLSKey:        pki.clientKeyFile,
+	}).tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := pki.handshake(t, cfg, true)
+	if err != nil {
+		t.Fatalf("handshake with a client certificate: %v", err)
+	}
+	if peer == nil || peer.Subject.CommonName != "mcp-client" {
+		t.Fatalf("server saw client certificate %v, want mcp-client", peer)
+	}
+
+	cfg, err = (&ProfileConfig{TLSMode: TLSRequired, TLSCA: pki.caFile, TLSServerName: "db.internal"}).tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pki.handshake(t, cfg, true); err == nil {
+		t.Fatal("handshake without a client certificate succeeded")
+	}
+}
+
+func TestApplyTLS(t *testing.T) {
+	pki := newTestPKI(t)
+
+	for _, tc := range []struct {
+		profile   ProfileConfig
+		tlsConfig string
+		fallback  bool
+	}{
+		{ProfileConfig{}, "", false},
+		{ProfileConfig{TLSMode: TLSDisabled}, "false", false},
+		{ProfileConfig{TLSMode: TLSRequired}, "true", false},
+		{ProfileConfig{TLSMode: TLSSkipVerify}, TLSSkipVerify, false},
+		{ProfileConfig{TLSMode: TLSPreferred}, TLSPreferred, false},
+		{ProfileConfig{TLSCA: pki.caFile}, "mysql-mcp-test", false},
+		{ProfileConfig{TLSMode: TLSRequired, TLSCA: pki.caFile}, "mysql-mcp-test", false},
+		{ProfileConfig{TLSMode: TLSPreferred, TLSCA: pki.caFile}, "mysql-mcp-test", true},
+	} {
+		cfg := mysql.NewConfig()
+		if err := applyTLS("test", &tc.profile, cfg); err != nil {
+			t.Fatalf("%+v: %v", tc.profile, err)
+		}
+		if cfg.TLSConfig != tc.tlsConfig || cfg.AllowFallbackToPlaintext != tc.fallback {
+			t.Errorf("%+v: got tls %q, fallback %v, want %q, %v", tc.profile, cfg.TLSConfig, cfg.AllowFallbackToPlaintext, tc.tlsConfig, tc.fallback)
+		}
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "not-pem.txt")
+	writeTestFile(t, notPEM, []byte("not a certificate"))
+
+	for name, p := range map[string]*ProfileConfig{
+		"tls_ca without certificates": {TLSMode: TLSRequired, TLSCA: notPEM},
+		"missing tls_ca":              {TLSMode: TLSRequired, TLSCA: filepath.Join(dir, "missing.pem")},
+		"tls_cert without tls_key":    {TLSMode: TLSRequired, TLSCert: pki.clientCertFile},
+		"tls_key of another cert":     {TLSMode: TLSRequired, TLSCert: pki.clientCertFile, TLSKey: pki.otherCAFile},
+	} {
+		if err := applyTLS("errors", p, mysql.NewConfig()); err == nil {
+			t.Errorf("%s: applyTLS succeeded", name)
+		}
+	}
+
+	p := &ProfileConfig{Host: "localhost", Port: 3306, TLSMode: TLSRequired, TLSCert: pki.clientCertFile}
+	if err := validateProfile("", p); err == nil {
+		t.Error("tls_cert without tls_key passed validation")
+	}
+}
@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxHexBytes is the longest binary value emitted as hex; longer values
+// (BLOBs, images) are emitted as base64.
+const maxHexBytes = 32
+
+// resultSet holds the encoded rows of a query.
+type resultSet struct {
+	columnTypes []*sql.ColumnType
+	rows        [][]interface{}
+	// truncated reports whether more rows were available
+	truncated bool
+	// overBudget reports whether rows were left out to stay within the
+	// response budget
+	overBudget bool
+	// offset is the number of rows of the result set returned before these
+	offset int
+	// masked holds the masking strategy of each column, nil if none is
+	// masked
+	masked []string
+}
+
+// readRows reads up to limit rows and encodes every value according to its
+// column type and masks it according to masks, which may be nil. Values are
+// cut off at the session's cell budget, and reading stops once the rows take
+// up more than maxBytes (0 for no limit).
+func (s *dbSession) readRows(rows *sql.Rows, limit int, maxBytes int64, masks *maskPlan) (*resultSet, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+
+	rs := &resultSet{columnTypes: columnTypes, masked: masks.strategies(columnNames(columnTypes))}
+	values := make([]interface{}, len(columnTypes))
+	valuePtrs := make([]interface{}, len(columnTypes))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	var size int64
+	for rows.Next() {
+		if len(rs.rows) >= limit {
+			rs.truncated = true
+			break
+		}
+
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		row := make([]interface{}, len(columnTypes))
+		for i, ct := range columnTypes {
+			v := s.encodeValue(ct, values[i])
+			if rs.masked != nil && rs.masked[i] != "" {
+				v = masks.policy.apply(rs.masked[i], v)
+			}
+			row[i], _ = s.budget.truncateCell(v)
+		}
+		rs.rows = append(rs.rows, row)
+
+		if maxBytes > 0 {
+			encoded, err := json.Marshal(row)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode row: %w", err)
+			}
+			if size += int64(len(encoded)); size > maxBytes {
+				rs.truncated = true
+				break
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return rs, nil
+}
+
+// scanRows reads the rows of a single response: at most limit rows within
+// the response budget.
+func (s *dbSession) scanRows(rows *sql.Rows, limit int, masks *maskPlan) (*resultSet, error) {
+	rs, err := s.readRows(rows, limit, s.budget.rowBytes(), masks)
+	if err != nil {
+		return nil, err
+	}
+	page, _, err := s.budget.page(rs, limit)
+	return page, err
+}
+
+// encodeValue converts a raw driver value into a JSON friendly value that
+// keeps the meaning of the column type:
+//   - NULL becomes null
+//   - DECIMAL becomes an exact string
+//   - BINARY, VARBINARY, BLOB and GEOMETRY become {"$hex": ...} for short
+//     values and {"$base64": ...} otherwise
+//   - BIT becomes an unsigned integer
+//   - JSON is embedded as JSON
+//   - DATETIME and TIMESTAMP become ISO-8601 with the session time zone
+//     offset, DATE and TIME stay as they are
+func (s *dbSession) encodeValue(ct *sql.ColumnType, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	typeName := ct.DatabaseTypeName()
+
+	if t, ok := v.(time.Time); ok {
+		return s.encodeTime(typeName, t)
+	}
+
+	switch val := v.(type) {
+	case int64, uint64, float64, bool:
+		return val
+	case float32:
+		// Keep the float's shortest representation instead of widening it
+		return json.Number(strconv.FormatFloat(float64(val), 'g', -1, 32))
+	case string:
+		v = []byte(val)
+	}
+
+	b, ok := v.([]byte)
+	if !ok {
+		return v
+	}
+
+	switch typeName {
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "GEOMETRY":
+		return encodeBinary(b)
+	case "BIT":
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n
+	case "JSON":
+		if json.Valid(b) {
+			return json.RawMessage(append([]byte(nil), b...))
+		}
+	case "DATETIME", "TIMESTAMP":
+		return s.encodeDateTime(string(b))
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			return n
+		}
+	case "UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED INT", "UNSIGNED BIGINT":
+		if n, err := strconv.ParseUint(string(b), 10, 64); err == nil {
+			return n
+		}
+	case "FLOAT", "DOUBLE":
+		if _, err := strconv.ParseFloat(string(b), 64); err == nil {
+			return json.Number(string(b))
+		}
+	}
+
+	// DECIMAL, DATE, TIME and all character types
+	return string(b)
+}
+
+// encodeBinary wraps binary data in a marker object so it cannot be
+// mistaken for text.
+func encodeBinary(b []byte) map[string]string {
+	if len(b) <= maxHexBytes {
+		return map[string]string{"$hex": hex.EncodeToString(b)}
+	}
+	return map[string]string{"$base64": base64.StdEncoding.EncodeToString(b)}
+}
+
+// encodeDateTime converts MySQL's "YYYY-MM-DD hh:mm:ss[.fraction]" text
+// format to ISO-8601 in the session time zone. Zero dates are kept as is.
+func (s *dbSession) encodeDateTime(value string) string {
+	if len(value) < 19 || strings.HasPrefix(value, "0000-00-00") {
+		return value
+	}
+	return value[:10] + "T" + value[11:] + s.tzOffset
+}
+
+// encodeTime formats a value decoded with parseTime. The driver places the
+// session's wall clock time in its configured location, so only the wall
+// clock is used and the session offset is appended.
+func (s *dbSession) encodeTime(typeName string, t time.Time) string {
+	switch typeName {
+	case "DATE":
+		return t.Format("2006-01-02")
+	case "DATETIME", "TIMESTAMP":
+		if t.IsZero() {
+			return "0000-00-00T00:00:00"
+		}
+		return t.Format("2006-01-02T15:04:05.999999") + s.tzOffset
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// formatTZOffset converts the result of TIMEDIFF(NOW(), UTC_TIMESTAMP())
+// (e.g. "02:00:00" or "-05:30:00") to an ISO-8601 offset such as "+02:00".
+func formatTZOffset(diff string) string {
+	sign := "+"
+	if strings.HasPrefix(diff, "-") {
+		sign = "-"
+		diff = diff[1:]
+	}
+	parts := strings.Split(diff, ":")
+	if len(parts) < 2 {
+		return ""
+	}
+	if sign == "+" && parts[0] == "00" && parts[1] == "00" {
+		return "Z"
+	}
+	return sign + parts[0] + ":" + parts[1]
+}